@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// GetCollations reads the locale through to_jsonb() as the column holding
+// it for ICU collations changed its name between versions
+const GetCollations string = `
+SELECT
+  c.collname,
+  c.collprovider::text,
+  COALESCE(to_jsonb(c)->>'collisdeterministic', 'true')::boolean,
+  COALESCE(to_jsonb(c)->>'colllocale', to_jsonb(c)->>'colliculocale', c.collcollate),
+  c.collcollate,
+  c.collctype,
+  obj_description(c.oid, 'pg_collation')
+FROM pg_catalog.pg_collation c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.collnamespace
+WHERE n.nspname = $1 AND
+  NOT EXISTS (
+    SELECT 1
+    FROM pg_catalog.pg_depend d
+    WHERE d.classid = 'pg_catalog.pg_collation'::regclass AND
+      d.objid = c.oid AND
+      d.deptype = 'e'
+  )
+ORDER BY c.collname
+`
+
+const GetDatabaseLocale string = `
+SELECT
+  pg_encoding_to_char(encoding),
+  datcollate,
+  datctype
+FROM pg_catalog.pg_database
+WHERE datname = current_database()
+`
+
+type Collation struct {
+	name          string
+	provider      string
+	deterministic bool
+	locale        sql.NullString
+	collate       sql.NullString
+	ctype         sql.NullString
+	comment       sql.NullString
+}
+
+func (collation *Collation) collect(rows *sql.Rows) error {
+	return rows.Scan(
+		&collation.name,
+		&collation.provider,
+		&collation.deterministic,
+		&collation.locale,
+		&collation.collate,
+		&collation.ctype,
+		&collation.comment,
+	)
+}
+
+func (collation *Collation) options() string {
+	var list []string
+	switch collation.provider {
+	case "i":
+		list = append(list, "provider = icu", fmt.Sprintf("locale = %s", quoteLiteral(collation.locale.String)))
+	case "b":
+		// Added in PostgreSQL 17, the locale is either C or C.UTF-8
+		list = append(list, "provider = builtin", fmt.Sprintf("locale = %s", quoteLiteral(collation.locale.String)))
+	default:
+		list = append(
+			list,
+			"provider = libc",
+			fmt.Sprintf("lc_collate = %s", quoteLiteral(collation.collate.String)),
+			fmt.Sprintf("lc_ctype = %s", quoteLiteral(collation.ctype.String)),
+		)
+	}
+	if !collation.deterministic {
+		list = append(list, "deterministic = false")
+	}
+	return strings.Join(list, ", ")
+}
+
+func (collation *Collation) CreateStatement() string {
+	return fmt.Sprintf("CREATE COLLATION IF NOT EXISTS \"%s\" (%s);\n", collation.name, collation.options())
+}
+
+func (collation *Collation) DropStatement() string {
+	return fmt.Sprintf("DROP COLLATION IF EXISTS \"%s\";\n", collation.name)
+}
+
+func (collation *Collation) Diff(target *Collation) string {
+	if collation.options() == target.options() {
+		return ""
+	}
+	// Collations cannot be altered, and columns using them would have to
+	// be converted to drop it
+	return fmt.Sprintf(
+		"-- \033[31mWARNING\033[0m: collation \"%s\" changed from (%s) to (%s), it has to be recreated manually\n",
+		collation.name,
+		target.options(),
+		collation.options(),
+	)
+}
+
+func (schema *Schema) collectCollations(db *sql.DB, schemaName string) error {
+	var rows *sql.Rows
+	var err error
+	if err = db.QueryRow(GetDatabaseLocale).Scan(&schema.encoding, &schema.collate, &schema.ctype); err != nil {
+		return err
+	}
+	if rows, err = db.Query(GetCollations, schemaName); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var collation Collation
+		if err = collation.collect(rows); err != nil {
+			return err
+		}
+		schema.collations = append(schema.collations, &collation)
+	}
+	return nil
+}
+
+func (schema *Schema) FindCollationByName(name string) *Collation {
+	for _, collation := range schema.collations {
+		if collation.name == name {
+			return collation
+		}
+	}
+	return nil
+}
+
+// localeWarnings are only informative, the default collation and encoding
+// of a database are fixed when it is created
+func (schema *Schema) localeWarnings(target *Schema) string {
+	var builder strings.Builder
+	if schema.encoding != target.encoding {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: databases use different encodings, %s and %s\n",
+				schema.encoding,
+				target.encoding,
+			),
+		)
+	}
+	if schema.collate != target.collate || schema.ctype != target.ctype {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: databases use different default collations, %s/%s and %s/%s\n",
+				schema.collate,
+				schema.ctype,
+				target.collate,
+				target.ctype,
+			),
+		)
+	}
+	return builder.String()
+}
+
+func (schema *Schema) examineCollations(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, collation := range schema.collations {
+		var found *Collation
+		found = target.FindCollationByName(collation.name)
+		if found == nil {
+			builder.WriteString(collation.CreateStatement())
+		} else {
+			builder.WriteString(collation.Diff(found))
+		}
+	}
+	return builder.String(), nil
+}
+
+func (schema *Schema) generateNeededDropCollationStatements(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, collation := range target.collations {
+		if schema.FindCollationByName(collation.name) == nil {
+			builder.WriteString(collation.DropStatement())
+		}
+	}
+	return builder.String(), nil
+}
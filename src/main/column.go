@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -13,43 +14,258 @@ SELECT
   ordinal_position,
   column_default,
   is_nullable,
-  quote_ident(udt_name),
-  character_maximum_length,
-  numeric_precision,
-  numeric_scale
+  format_type(a.atttypid, a.atttypmod),
+  format_type(a.atttypid, NULL),
+  a.atttypmod,
+  a.attislocal,
+  (
+    SELECT quote_ident(c.collname)
+    FROM pg_catalog.pg_collation c
+    JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+    WHERE c.oid = a.attcollation AND
+      a.attcollation <> t.typcollation
+  ),
+  CASE WHEN is_identity = 'YES' THEN identity_generation END,
+  identity_start,
+  identity_increment,
+  identity_minimum,
+  identity_maximum,
+  identity_cycle = 'YES',
+  CASE WHEN is_generated = 'ALWAYS' THEN generation_expression END,
+  sequence.nspname,
+  sequence.relname,
+  col_description(a.attrelid, a.attnum),
+  a.attstorage::text,
+  (SELECT t.typstorage::text FROM pg_catalog.pg_type t WHERE t.oid = a.atttypid),
+  -- attcompression was added in PostgreSQL 14
+  NULLIF(to_jsonb(a)->>'attcompression', ''),
+  NULLIF(COALESCE(a.attstattarget, -1), -1),
+  a.attfdwoptions,
+  -- Functions are matched by name, any volatile overload is enough
+  COALESCE((
+    SELECT bool_or(p.provolatile = 'v')
+    FROM regexp_matches(column_default, '"?([[:alnum:]_]+)"?\(', 'g') AS m(name)
+    JOIN pg_catalog.pg_proc p ON p.proname = m.name[1]
+  ), FALSE)
 FROM
   information_schema.columns
+JOIN pg_catalog.pg_attribute a
+  ON a.attrelid = quote_ident($1)::regclass AND
+  a.attname = column_name
+LEFT JOIN LATERAL (
+  SELECT n.nspname, s.relname
+  FROM pg_catalog.pg_attrdef ad
+  JOIN pg_catalog.pg_depend d
+    ON d.classid = 'pg_catalog.pg_attrdef'::regclass AND
+    d.objid = ad.oid AND
+    d.refclassid = 'pg_catalog.pg_class'::regclass
+  JOIN pg_catalog.pg_class s ON s.oid = d.refobjid AND s.relkind = 'S'
+  JOIN pg_catalog.pg_namespace n ON n.oid = s.relnamespace
+  WHERE ad.adrelid = quote_ident($1)::regclass AND
+    ad.adnum = ordinal_position
+) sequence ON TRUE
 WHERE
   table_name = $1 AND 
   table_catalog = $2 AND 
   table_schema = $3
 `
 
+// GetColumnIndexes lists the indexes using the column that do not back a
+// constraint, those depend on the constraint instead; names are also quoted
+// the way pg_get_indexdef() writes them
+const GetColumnIndexes string = `
+SELECT DISTINCT i.relname, quote_ident(i.relname), pg_get_indexdef(i.oid), quote_ident(a.attname)
+FROM pg_catalog.pg_depend d
+JOIN pg_catalog.pg_class i ON i.oid = d.objid AND i.relkind = 'i'
+JOIN pg_catalog.pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+WHERE d.classid = 'pg_catalog.pg_class'::regclass AND
+  d.refclassid = 'pg_catalog.pg_class'::regclass AND
+  d.refobjid = quote_ident($1)::regclass AND
+  d.refobjsubid = $2
+ORDER BY i.relname
+`
+
+// GetColumnConstraints lists the constraints using the column, including
+// foreign keys of other tables referencing it, foreign keys come first.
+// Primary keys and unique constraints come with their index, foreign keys
+// with their columns and the referenced ones where the column is replaced
+// by $3
+const GetColumnConstraints string = `
+SELECT DISTINCT
+  c.contype = 'f',
+  c.conrelid::regclass::text,
+  c.conname,
+  pg_get_constraintdef(c.oid),
+  i.relname IS NOT NULL,
+  COALESCE(i.relname, ''),
+  COALESCE(quote_ident(i.relname), ''),
+  COALESCE(pg_get_indexdef(i.oid), ''),
+  quote_ident(a.attname),
+  COALESCE(c.confrelid::regclass::text, ''),
+  COALESCE(foreign_key.columns, ''),
+  COALESCE(foreign_key.referenced, '')
+FROM pg_catalog.pg_depend d
+JOIN pg_catalog.pg_constraint c ON c.oid = d.objid
+JOIN pg_catalog.pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+LEFT JOIN pg_catalog.pg_class i ON i.oid = c.conindid AND c.contype IN ('p', 'u')
+LEFT JOIN LATERAL (
+  SELECT
+    (
+      SELECT string_agg(
+        CASE WHEN c.conrelid = d.refobjid AND k.attnum = d.refobjsubid THEN quote_ident($3) ELSE quote_ident(f.attname) END,
+        ', ' ORDER BY k.n
+      )
+      FROM unnest(c.conkey) WITH ORDINALITY k(attnum, n)
+      JOIN pg_catalog.pg_attribute f ON f.attrelid = c.conrelid AND f.attnum = k.attnum
+    ) AS columns,
+    (
+      SELECT string_agg(
+        CASE WHEN c.confrelid = d.refobjid AND k.attnum = d.refobjsubid THEN quote_ident($3) ELSE quote_ident(f.attname) END,
+        ', ' ORDER BY k.n
+      )
+      FROM unnest(c.confkey) WITH ORDINALITY k(attnum, n)
+      JOIN pg_catalog.pg_attribute f ON f.attrelid = c.confrelid AND f.attnum = k.attnum
+    ) AS referenced
+  WHERE c.contype = 'f'
+) foreign_key ON TRUE
+WHERE d.classid = 'pg_catalog.pg_constraint'::regclass AND
+  d.refclassid = 'pg_catalog.pg_class'::regclass AND
+  d.refobjid = quote_ident($1)::regclass AND
+  d.refobjsubid = $2 AND
+  c.contype <> 'n'
+ORDER BY 1 DESC, 2, 3
+`
+
+// GetColumnViews lists the views using the column, directly or through
+// other views, ordered so that every view comes after the ones it uses
+const GetColumnViews string = `
+WITH RECURSIVE views(oid, depth) AS (
+  SELECT r.ev_class, 1
+  FROM pg_catalog.pg_depend d
+  JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+  WHERE d.classid = 'pg_catalog.pg_rewrite'::regclass AND
+    d.refclassid = 'pg_catalog.pg_class'::regclass AND
+    d.refobjid = quote_ident($1)::regclass AND
+    d.refobjsubid = $2 AND
+    r.ev_class <> d.refobjid
+  UNION
+  SELECT r.ev_class, views.depth + 1
+  FROM views
+  JOIN pg_catalog.pg_depend d
+    ON d.classid = 'pg_catalog.pg_rewrite'::regclass AND
+    d.refclassid = 'pg_catalog.pg_class'::regclass AND
+    d.refobjid = views.oid
+  JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+  WHERE r.ev_class <> views.oid
+)
+SELECT c.relname, c.relkind = 'm', pg_get_viewdef(c.oid), ARRAY(
+  SELECT pg_get_triggerdef(t.oid)
+  FROM pg_catalog.pg_trigger t
+  WHERE t.tgrelid = c.oid AND NOT t.tgisinternal
+  ORDER BY t.tgname
+)
+FROM views
+JOIN pg_catalog.pg_class c ON c.oid = views.oid
+GROUP BY c.oid
+ORDER BY MAX(views.depth), c.relname
+`
+
 type Column struct {
-	name             string
-	position         int
-	defaultValue     interface{}
-	isNullable       bool
-	dataType         string
-	length           sql.NullInt64
-	table            *Table
-	constraints      []*Constraint
-	from             int
-	isAutoincrement  bool
-	numericPrecision sql.NullInt64
-	numericScale     sql.NullInt64
+	name         string
+	position     int
+	defaultValue interface{}
+	isNullable   bool
+	// Exact type as printed by format_type(), including the modifiers
+	dataType        string
+	table           *Table
+	constraints     []*Constraint
+	from            int
+	isAutoincrement bool
+	// Type without modifiers and the raw modifier, -1 if there is none
+	baseType string
+	typmod   int
+	// Only set if it is not the default collation of the type
+	collation sql.NullString
+	// False if the column is only inherited from a parent table
+	isLocal bool
+	// Either ALWAYS or BY DEFAULT for identity columns
+	identity          sql.NullString
+	identityStart     sql.NullString
+	identityIncrement sql.NullString
+	identityMinimum   sql.NullString
+	identityMaximum   sql.NullString
+	identityCycle     sql.NullBool
+	// Expression of GENERATED ALWAYS AS (...) STORED columns
+	generationExpression sql.NullString
+	comment              sql.NullString
+	// Storage strategy of the column and the default one of its type
+	storage     string
+	typeStorage string
+	compression sql.NullString
+	// Statistics target, not set if it uses default_statistics_target
+	statistics sql.NullInt64
+	// Options of the columns of foreign tables
+	fdwOptions stringArray
+	// True if the default calls a volatile function, e.g. random()
+	volatileDefault bool
 }
 
 func (column *Column) GetTypeString() string {
-	var length sql.NullInt64
-	var code strings.Builder
-	// The base is always the same
-	code.WriteString(fmt.Sprintf("%s", column.dataType))
-	length = column.length
-	if length.Valid {
-		code.WriteString(fmt.Sprintf("(%d)", length.Int64))
+	// format_type() already includes length, precision, scale, fields and
+	// array dimensions
+	return column.dataType
+}
+
+// GetTypeStringWithCollation is the type as used in column definitions,
+// with its COLLATE clause if the collation is not the default one
+func (column *Column) GetTypeStringWithCollation() string {
+	if column.collation.Valid {
+		return fmt.Sprintf("%s COLLATE %s", column.dataType, column.collation.String)
 	}
-	return code.String()
+	return column.dataType
+}
+
+// typeChangeImpact tells whether changing the type of the column to the one
+// of the target rewrites the table, changes that only relax the modifier of
+// the type are done in the catalog alone
+func (column *Column) typeChangeImpact(target *Column) Impact {
+	var relaxed bool
+	if column.baseType == "character varying" && target.baseType == "text" {
+		return MetadataOnly
+	} else if column.baseType != target.baseType {
+		return FullRewrite
+	}
+	relaxed = target.typmod == -1 || (column.typmod != -1 && target.typmod >= column.typmod)
+	switch column.baseType {
+	case "character varying", "bit varying":
+		if relaxed {
+			return MetadataOnly
+		}
+	case "timestamp without time zone", "timestamp with time zone", "time without time zone", "time with time zone":
+		if relaxed {
+			return MetadataOnly
+		}
+	case "interval":
+		// The modifier is (fields << 16) | precision, the precision being
+		// 0xffff when not given; only a wider precision keeps the values
+		if target.typmod == -1 {
+			return MetadataOnly
+		} else if column.typmod != -1 &&
+			column.typmod>>16 == target.typmod>>16 &&
+			target.typmod&0xffff >= column.typmod&0xffff {
+			return MetadataOnly
+		}
+	case "numeric":
+		// The modifier is ((precision << 16) | scale) + 4
+		if target.typmod == -1 {
+			return MetadataOnly
+		} else if column.typmod != -1 &&
+			(column.typmod-4)&0xffff == (target.typmod-4)&0xffff &&
+			(column.typmod-4)>>16 <= (target.typmod-4)>>16 {
+			return MetadataOnly
+		}
+	}
+	return FullRewrite
 }
 
 func (column *Column) GetDefaultValue() (string, error) {
@@ -57,7 +273,7 @@ func (column *Column) GetDefaultValue() (string, error) {
 	defaultValue = column.defaultValue
 	switch value := defaultValue.(type) {
 	case *Sequence:
-		return fmt.Sprintf("NEXTVAL('\"%s\"')", value.name), nil
+		return fmt.Sprintf("nextval('%s'::regclass)", value.QualifiedName()), nil
 	case string:
 		return value, nil
 	}
@@ -69,8 +285,18 @@ func (column Column) String() string {
 	var err error
 	var defaultValue string
 	code.WriteString(fmt.Sprintf("\"%s\" %s", column.name, column.GetTypeString()))
+	if len(column.fdwOptions) > 0 {
+		code.WriteString(fmt.Sprintf(" %s", genericOptions(column.fdwOptions)))
+	}
+	if column.collation.Valid {
+		code.WriteString(fmt.Sprintf(" COLLATE %s", column.collation.String))
+	}
 	defaultValue, err = column.GetDefaultValue()
-	if err == nil {
+	if column.generationExpression.Valid {
+		code.WriteString(fmt.Sprintf(" GENERATED ALWAYS AS (%s) STORED", column.generationExpression.String))
+	} else if column.identity.Valid {
+		code.WriteString(column.identityClause())
+	} else if err == nil {
 		code.WriteString(fmt.Sprintf(" DEFAULT %s", defaultValue))
 	}
 	if !column.isNullable {
@@ -79,87 +305,490 @@ func (column Column) String() string {
 	return code.String()
 }
 
-func (column *Column) Diff(target *Column) (string, error) {
-	var defaultValue interface{}
-	var otherDefaultValue interface{}
+// onlineSetNotNull replaces SET NOT NULL, which scans the table under an
+// ACCESS EXCLUSIVE lock, by a NOT VALID check constraint that is validated
+// in a later transaction; SET NOT NULL can then use the validated
+// constraint instead of scanning the table
+func (column *Column) onlineSetNotNull(migration *Migration) string {
 	var table *Table
-	var builder strings.Builder
+	var name string
 	table = column.table
-	if column.isNullable && !target.isNullable {
-		builder.WriteString(
-			fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET NOT NULL;\n", table.name, column.name),
-		)
-	} else if !column.isNullable && target.isNullable {
-		builder.WriteString(
-			fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" DROP NOT NULL;\n", table.name, column.name),
-		)
+	name = fmt.Sprintf("%s_%s_not_null", table.name, column.name)
+	migration.Write(Validate, table.ValidateConstraintStatement(name))
+	migration.Write(Contract, table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET NOT NULL;\n", table.name, column.name),
+		AccessExclusive,
+		MetadataOnly,
+	))
+	migration.Write(Contract, table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" DROP CONSTRAINT IF EXISTS \"%s\";\n", table.name, name),
+		AccessExclusive,
+		MetadataOnly,
+	))
+	return table.annotate(
+		fmt.Sprintf(
+			"ALTER TABLE \"%s\" ADD CONSTRAINT \"%s\" CHECK (\"%s\" IS NOT NULL) NOT VALID;\n",
+			table.name,
+			name,
+			column.name,
+		),
+		AccessExclusive,
+		MetadataOnly,
+	)
+}
+
+// viewStatements reads the name, kind, definition and triggers of views
+// ordered so that every view comes after the ones it uses, and returns the
+// statements dropping and creating them again along with their names
+func viewStatements(rows *sql.Rows) (string, string, []string, error) {
+	var drop strings.Builder
+	var create strings.Builder
+	var names []string
+	var err error
+	for rows.Next() {
+		var name string
+		var materialized bool
+		var definition string
+		var triggers stringArray
+		var keyword string = "VIEW"
+		if err = rows.Scan(&name, &materialized, &definition, &triggers); err != nil {
+			return "", "", nil, err
+		}
+		names = append(names, name)
+		if materialized {
+			keyword = "MATERIALIZED VIEW"
+		}
+		create.WriteString(fmt.Sprintf("CREATE %s \"%s\" AS\n  %s;\n", keyword, name, removeSemicolon(definition)))
+		for _, trigger := range triggers {
+			create.WriteString(fmt.Sprintf("%s;\n", trigger))
+		}
+		// CASCADE takes the views using this one, they come later
+		drop.WriteString(fmt.Sprintf("DROP %s IF EXISTS \"%s\" CASCADE;\n", keyword, name))
 	}
-	if column.dataType == "\"numeric\"" && differentPrecisionOrScale(target, column) {
-	} else if target.dataType != column.dataType || target.length != column.length {
-		builder.WriteString(
-			fmt.Sprintf(
-				"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" TYPE %s USING \"%s\"::%s;\n",
-				table.name,
-				column.name,
-				target.GetTypeString(),
-				column.name,
-				target.dataType,
-			),
-		)
+	return drop.String(), create.String(), names, nil
+}
+
+// ColumnDependents are the statements moving the views, constraints and
+// indexes using a column over to the shadow column replacing it
+type ColumnDependents struct {
+	// Dropped along with the old column
+	drop string
+	// Indexes built concurrently on the shadow column once it is filled
+	indexes string
+	// Foreign keys added to the shadow column and validated
+	foreignKeys string
+	// Created again once the shadow column has taken its name
+	recreate string
+	// Whether recreating them scans the table
+	impact Impact
+}
+
+// shadowIndex rewrites the definition of an index using the column so that
+// it is built concurrently on the shadow column under another name, the
+// names are matched quoted the way pg_get_indexdef() writes them
+func shadowIndex(definition string, name string, column string, index string, shadow string) string {
+	var pattern *regexp.Regexp
+	var position int
+	var columns string
+	var replaced string
+	position = strings.Index(definition, " USING ")
+	if position < 0 {
+		return definition
 	}
-	defaultValue = column.defaultValue
-	otherDefaultValue = target.defaultValue
-	switch value := defaultValue.(type) {
-	case nil:
-		if target.defaultValue != nil {
-			var str, err = target.GetDefaultValue()
-			if err != nil {
-				return "", err
+	pattern = regexp.MustCompile(`([^[:alnum:]_"$.'])` + regexp.QuoteMeta(column) + `([^[:alnum:]_"$.'])`)
+	columns = definition[position:] + " "
+	// Matches next to each other share the character between them
+	for replaced != columns {
+		replaced = columns
+		columns = pattern.ReplaceAllString(columns, fmt.Sprintf("${1}\"%s\"${2}", shadow))
+	}
+	return strings.Replace(
+		definition[:position],
+		fmt.Sprintf(" INDEX %s ON ", name),
+		fmt.Sprintf(" INDEX CONCURRENTLY IF NOT EXISTS \"%s\" ON ", index),
+		1,
+	) + strings.TrimSuffix(columns, " ")
+}
+
+// foreignKeyActions returns what follows the referenced columns in the
+// definition of a foreign key, i.e. MATCH, ON UPDATE, ON DELETE and
+// DEFERRABLE
+func foreignKeyActions(definition string) string {
+	var position int
+	position = strings.Index(definition, ") REFERENCES ")
+	if position < 0 {
+		return ""
+	}
+	definition = definition[position+len(") REFERENCES "):]
+	if position = strings.Index(definition, ")"); position < 0 {
+		return ""
+	}
+	return definition[position+1:]
+}
+
+// dependents returns the statements moving the views, constraints and
+// indexes using the column over to the shadow column, they are read from
+// the target database. Partitioned tables can neither build indexes
+// concurrently nor attach them to constraints, everything is created again
+// after the swap there
+func (column *Column) dependents(shadow string, migration *Migration) (*ColumnDependents, error) {
+	var dependents ColumnDependents
+	var rows *sql.Rows
+	var drop strings.Builder
+	var indexes strings.Builder
+	var foreignKeys strings.Builder
+	var dropViews string
+	var views string
+	var constraints []string
+	var renames []string
+	var online bool
+	var err error
+	if migration.db == nil {
+		return nil, errors.New("no connection to the target database")
+	}
+	online = !column.table.partitionKey.Valid
+	dependents.impact = MetadataOnly
+	if rows, err = migration.db.Query(GetColumnViews, column.table.name, column.position); err != nil {
+		return nil, err
+	}
+	if dropViews, views, _, err = viewStatements(rows); err != nil {
+		return nil, err
+	}
+	drop.WriteString(dropViews)
+	if rows, err = migration.db.Query(GetColumnConstraints, column.table.name, column.position, shadow); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var foreign bool
+		var table string
+		var name string
+		var definition string
+		var key bool
+		var index string
+		var quotedIndex string
+		var indexDefinition string
+		var quotedColumn string
+		var referencedTable string
+		var columns string
+		var referenced string
+		var keyword string = "UNIQUE"
+		var deferrable string
+		var position int
+		err = rows.Scan(
+			&foreign,
+			&table,
+			&name,
+			&definition,
+			&key,
+			&index,
+			&quotedIndex,
+			&indexDefinition,
+			&quotedColumn,
+			&referencedTable,
+			&columns,
+			&referenced,
+		)
+		if err != nil {
+			return nil, err
+		}
+		drop.WriteString(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS \"%s\";\n", table, name))
+		if online && key {
+			if strings.HasPrefix(definition, "PRIMARY KEY") {
+				keyword = "PRIMARY KEY"
 			}
-			builder.WriteString(
+			if position = strings.Index(definition, " DEFERRABLE"); position >= 0 {
+				deferrable = definition[position:]
+			}
+			indexes.WriteString(
+				fmt.Sprintf("%s;\n", shadowIndex(indexDefinition, quotedIndex, quotedColumn, index+"__new", shadow)),
+			)
+			// Keys are attached before the foreign keys using them
+			constraints = append(
+				[]string{
+					fmt.Sprintf(
+						"ALTER TABLE %s ADD CONSTRAINT \"%s\" %s USING INDEX \"%s__new\"%s;\n",
+						table,
+						name,
+						keyword,
+						index,
+						deferrable,
+					),
+				},
+				constraints...,
+			)
+		} else if online && foreign {
+			// The referenced columns need their unique index, so they are
+			// added once the indexes are built
+			foreignKeys.WriteString(
 				fmt.Sprintf(
-					"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET DEFAULT %s;\n",
-					table.name,
-					column.name,
-					str,
+					"ALTER TABLE %s ADD CONSTRAINT \"%s__new\" FOREIGN KEY (%s) REFERENCES %s(%s)%s NOT VALID;\n",
+					table,
+					name,
+					columns,
+					referencedTable,
+					referenced,
+					foreignKeyActions(definition),
 				),
 			)
+			foreignKeys.WriteString(fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT \"%s__new\";\n", table, name))
+			renames = append(
+				renames,
+				fmt.Sprintf("ALTER TABLE %s RENAME CONSTRAINT \"%s__new\" TO \"%s\";\n", table, name, name),
+			)
+		} else {
+			dependents.impact = FullScan
+			constraints = append(
+				[]string{fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT \"%s\" %s;\n", table, name, definition)},
+				constraints...,
+			)
 		}
-	case *Sequence:
-		switch otherValue := otherDefaultValue.(type) {
-		case nil:
-			builder.WriteString(fmt.Sprintf("DROP SEQUENCE IF EXISTS \"%s\";\n", value.name))
-			break
-		case *Sequence:
-			if value.name != otherValue.name {
-				builder.WriteString(
-					fmt.Sprintf(
-						"ALTER SEQUENCE \"%s\" RENAME TO \"%s\";\n",
-						value.name,
-						otherValue.name,
-					),
-				)
-			}
+	}
+	if rows, err = migration.db.Query(GetColumnIndexes, column.table.name, column.position); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		var quotedName string
+		var definition string
+		var quotedColumn string
+		if err = rows.Scan(&name, &quotedName, &definition, &quotedColumn); err != nil {
+			return nil, err
 		}
-		break
-	case string:
-		if value != target.defaultValue {
-			builder.WriteString(
-				fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" DROP DEFAULT;\n", table.name, column.name),
+		drop.WriteString(fmt.Sprintf("DROP INDEX IF EXISTS \"%s\";\n", name))
+		if online {
+			indexes.WriteString(
+				fmt.Sprintf("%s;\n", shadowIndex(definition, quotedName, quotedColumn, name+"__new", shadow)),
 			)
+			renames = append(renames, fmt.Sprintf("ALTER INDEX \"%s__new\" RENAME TO \"%s\";\n", name, name))
+		} else {
+			dependents.impact = FullScan
+			constraints = append([]string{fmt.Sprintf("%s;\n", definition)}, constraints...)
 		}
-		break
 	}
-	return builder.String(), nil
+	dependents.drop = drop.String()
+	dependents.indexes = indexes.String()
+	dependents.foreignKeys = foreignKeys.String()
+	dependents.recreate = strings.Join(constraints, "") + strings.Join(renames, "") + views
+	return &dependents, nil
 }
 
-func nullIntAreEqual(a sql.NullInt64, b sql.NullInt64) bool {
-	if !a.Valid && !b.Valid {
-		return true
+// expandTypeChange replaces ALTER COLUMN ... TYPE, which rewrites the table
+// under an ACCESS EXCLUSIVE lock, with a new column kept in sync by a
+// trigger, a batched backfill and finally a swap of both columns
+func (column *Column) expandTypeChange(target *Column, migration *Migration) (string, error) {
+	var table *Table
+	var builder strings.Builder
+	var contract strings.Builder
+	var shadow string
+	var function string
+	var defaultValue string
+	var dependents *ColumnDependents
+	var sequence *Sequence
+	var owned bool
+	var err error
+	table = column.table
+	shadow = fmt.Sprintf("%s__new", column.name)
+	// Read them before anything is written, the migration cannot be
+	// generated safely without them
+	if dependents, err = column.dependents(shadow, migration); err != nil {
+		return "", err
+	}
+	function = fmt.Sprintf("%s_%s_sync", table.name, column.name)
+	// Expand: add the new column and keep it up to date for new writes
+	builder.WriteString(table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" ADD COLUMN \"%s\" %s;\n", table.name, shadow, target.GetTypeStringWithCollation()),
+		AccessExclusive,
+		MetadataOnly,
+	))
+	builder.WriteString(
+		fmt.Sprintf(
+			"CREATE OR REPLACE FUNCTION \"%s\"() RETURNS trigger LANGUAGE plpgsql AS $$\n"+
+				"BEGIN\n"+
+				"  NEW.\"%s\" := NEW.\"%s\"::%s;\n"+
+				"  RETURN NEW;\n"+
+				"END\n"+
+				"$$;\n",
+			function,
+			shadow,
+			column.name,
+			target.GetTypeString(),
+		),
+	)
+	builder.WriteString(table.annotate(
+		fmt.Sprintf(
+			"CREATE TRIGGER \"%s\" BEFORE INSERT OR UPDATE ON \"%s\" FOR EACH ROW EXECUTE FUNCTION \"%s\"();\n",
+			function,
+			table.name,
+			function,
+		),
+		ShareRowExclusive,
+		MetadataOnly,
+	))
+	// Backfill: copy the existing rows in small batches, each one in its
+	// own transaction
+	migration.Write(Backfill, fmt.Sprintf(
+		"DO $$\n"+
+			"DECLARE\n"+
+			"  updated bigint;\n"+
+			"BEGIN\n"+
+			"  LOOP\n"+
+			"    UPDATE \"%s\" SET \"%s\" = \"%s\"::%s\n"+
+			"    WHERE ctid IN (\n"+
+			"      SELECT ctid FROM \"%s\" WHERE \"%s\" IS NULL AND \"%s\" IS NOT NULL LIMIT %d\n"+
+			"    );\n"+
+			"    GET DIAGNOSTICS updated = ROW_COUNT;\n"+
+			"    EXIT WHEN updated = 0;\n"+
+			"    COMMIT;\n"+
+			"  END LOOP;\n"+
+			"END\n"+
+			"$$;\n",
+		table.name,
+		shadow,
+		column.name,
+		target.GetTypeString(),
+		table.name,
+		shadow,
+		column.name,
+		migration.options.batchSize,
+	))
+	migration.Write(Backfill, dependents.indexes)
+	migration.Write(Validate, dependents.foreignKeys)
+	if !target.isNullable {
+		// Validated along with the other constraints, the column is set
+		// NOT NULL before the swap without scanning the table
+		builder.WriteString((&Column{name: shadow, table: table}).onlineSetNotNull(migration))
+	}
+	// Contract: swap the columns and remove the old one, the indexes and
+	// foreign keys built beforehand take the names of the old ones, views
+	// and other constraints are created again on the new column once it
+	// has taken its name
+	contract.WriteString(fmt.Sprintf("DROP TRIGGER IF EXISTS \"%s\" ON \"%s\";\n", function, table.name))
+	contract.WriteString(fmt.Sprintf("DROP FUNCTION IF EXISTS \"%s\"();\n", function))
+	sequence, owned = column.defaultValue.(*Sequence)
+	if owned && sequence.ownerTable.String == table.name && sequence.ownerColumn.String == column.name {
+		// The sequence would be dropped along with the old column
+		contract.WriteString(
+			fmt.Sprintf("ALTER SEQUENCE %s OWNED BY \"%s\".\"%s\";\n", sequence.QualifiedName(), table.name, shadow),
+		)
+	}
+	if target.identity.Valid {
+		// So is the sequence of the identity, the new one starts where
+		// it stopped
+		contract.WriteString(
+			fmt.Sprintf(
+				"SELECT set_config('pg_diff_schema.last_value', "+
+					"COALESCE(pg_sequence_last_value(pg_get_serial_sequence('\"%s\"', '%s')::regclass)::text, ''), true);\n",
+				table.name,
+				column.name,
+			),
+		)
+	}
+	contract.WriteString(dependents.drop)
+	contract.WriteString(fmt.Sprintf("ALTER TABLE \"%s\" DROP COLUMN \"%s\";\n", table.name, column.name))
+	contract.WriteString(
+		fmt.Sprintf("ALTER TABLE \"%s\" RENAME COLUMN \"%s\" TO \"%s\";\n", table.name, shadow, column.name),
+	)
+	if defaultValue, err = target.GetDefaultValue(); err == nil {
+		contract.WriteString(
+			fmt.Sprintf(
+				"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET DEFAULT %s;\n",
+				table.name,
+				column.name,
+				defaultValue,
+			),
+		)
+	}
+	if target.identity.Valid {
+		contract.WriteString(
+			fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" ADD%s;\n", table.name, column.name, target.identityClause()),
+		)
+		contract.WriteString(
+			fmt.Sprintf(
+				"SELECT setval(pg_get_serial_sequence('\"%s\"', '%s'), current_setting('pg_diff_schema.last_value')::bigint)\n"+
+					"WHERE current_setting('pg_diff_schema.last_value') <> '';\n",
+				table.name,
+				column.name,
+			),
+		)
 	}
-	return a.Int64 == b.Int64
+	contract.WriteString(dependents.recreate)
+	migration.Write(Contract, table.annotate(contract.String(), AccessExclusive, dependents.impact))
+	return builder.String(), nil
 }
 
-func differentPrecisionOrScale(a *Column, b *Column) bool {
-	return nullIntAreEqual(a.numericPrecision, b.numericPrecision) && nullIntAreEqual(a.numericScale, b.numericScale)
+func (column *Column) Diff(target *Column, migration *Migration) (string, error) {
+	var table *Table
+	var builder strings.Builder
+	var identity string
+	var regenerate bool
+	var statements string
+	var err error
+	table = column.table
+	if regenerate, statements = column.generationDiff(target); regenerate {
+		return statements, nil
+	}
+	builder.WriteString(statements)
+	if column.isNullable && !target.isNullable && migration.options.online {
+		builder.WriteString(column.onlineSetNotNull(migration))
+	} else if column.isNullable && !target.isNullable {
+		builder.WriteString(table.annotate(
+			fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET NOT NULL;\n", table.name, column.name),
+			AccessExclusive,
+			FullScan,
+		))
+	} else if !column.isNullable && target.isNullable {
+		builder.WriteString(table.annotate(
+			fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" DROP NOT NULL;\n", table.name, column.name),
+			AccessExclusive,
+			MetadataOnly,
+		))
+	}
+	if target.dataType != column.dataType && migration.options.online && column.typeChangeImpact(target) == FullRewrite {
+		if statements, err = column.expandTypeChange(target, migration); err != nil {
+			return "", err
+		}
+		builder.WriteString(statements)
+	} else if target.dataType != column.dataType {
+		builder.WriteString(table.annotate(
+			fmt.Sprintf(
+				"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" TYPE %s USING \"%s\"::%s;\n",
+				table.name,
+				column.name,
+				target.GetTypeStringWithCollation(),
+				column.name,
+				target.GetTypeString(),
+			),
+			AccessExclusive,
+			column.typeChangeImpact(target),
+		))
+	} else if target.collation != column.collation {
+		var collation string = "\"default\""
+		if target.collation.Valid {
+			collation = target.collation.String
+		}
+		// The data stays the same, but indexes on the column are rebuilt
+		builder.WriteString(table.annotate(
+			fmt.Sprintf(
+				"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" TYPE %s COLLATE %s;\n",
+				table.name,
+				column.name,
+				target.GetTypeString(),
+				collation,
+			),
+			AccessExclusive,
+			FullScan,
+		))
+	}
+	// An identity column cannot have a default, so the identity is dropped
+	// before setting a default and added after dropping it
+	identity = column.identityDiff(target)
+	if !target.identity.Valid {
+		builder.WriteString(identity)
+	}
+	builder.WriteString(column.defaultDiff(target, migration))
+	if target.identity.Valid {
+		builder.WriteString(identity)
+	}
+	builder.WriteString(column.storageDiff(target))
+	return builder.String(), nil
 }
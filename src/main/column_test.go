@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// numericTypmod is the modifier of numeric(precision, scale)
+func numericTypmod(precision int, scale int) int {
+	return (precision<<16 | scale) + 4
+}
+
+func TestTypeChangeImpact(t *testing.T) {
+	var tests = []struct {
+		name    string
+		current Column
+		wanted  Column
+		impact  Impact
+	}{
+		{
+			"varchar widened",
+			Column{baseType: "character varying", typmod: 14},
+			Column{baseType: "character varying", typmod: 24},
+			MetadataOnly,
+		},
+		{
+			"varchar narrowed",
+			Column{baseType: "character varying", typmod: 24},
+			Column{baseType: "character varying", typmod: 14},
+			FullRewrite,
+		},
+		{
+			"varchar unbounded",
+			Column{baseType: "character varying", typmod: 24},
+			Column{baseType: "character varying", typmod: -1},
+			MetadataOnly,
+		},
+		{
+			"varchar bounded",
+			Column{baseType: "character varying", typmod: -1},
+			Column{baseType: "character varying", typmod: 24},
+			FullRewrite,
+		},
+		{
+			"varchar to text",
+			Column{baseType: "character varying", typmod: 24},
+			Column{baseType: "text", typmod: -1},
+			MetadataOnly,
+		},
+		{
+			"integer to bigint",
+			Column{baseType: "integer", typmod: -1},
+			Column{baseType: "bigint", typmod: -1},
+			FullRewrite,
+		},
+		{
+			"numeric precision widened",
+			Column{baseType: "numeric", typmod: numericTypmod(10, 2)},
+			Column{baseType: "numeric", typmod: numericTypmod(12, 2)},
+			MetadataOnly,
+		},
+		{
+			"numeric precision narrowed",
+			Column{baseType: "numeric", typmod: numericTypmod(12, 2)},
+			Column{baseType: "numeric", typmod: numericTypmod(10, 2)},
+			FullRewrite,
+		},
+		{
+			"numeric scale changed",
+			Column{baseType: "numeric", typmod: numericTypmod(10, 2)},
+			Column{baseType: "numeric", typmod: numericTypmod(12, 4)},
+			FullRewrite,
+		},
+		{
+			"numeric unconstrained",
+			Column{baseType: "numeric", typmod: numericTypmod(10, 2)},
+			Column{baseType: "numeric", typmod: -1},
+			MetadataOnly,
+		},
+		{
+			"numeric constrained",
+			Column{baseType: "numeric", typmod: -1},
+			Column{baseType: "numeric", typmod: numericTypmod(10, 2)},
+			FullRewrite,
+		},
+		{
+			"timestamp precision widened",
+			Column{baseType: "timestamp with time zone", typmod: 3},
+			Column{baseType: "timestamp with time zone", typmod: 6},
+			MetadataOnly,
+		},
+		{
+			"time with time zone precision narrowed",
+			Column{baseType: "time with time zone", typmod: 6},
+			Column{baseType: "time with time zone", typmod: 3},
+			FullRewrite,
+		},
+		{
+			"interval precision widened",
+			Column{baseType: "interval", typmod: 0x7fff<<16 | 3},
+			Column{baseType: "interval", typmod: 0x7fff<<16 | 6},
+			MetadataOnly,
+		},
+		{
+			"interval fields changed",
+			Column{baseType: "interval", typmod: 0x7fff<<16 | 0xffff},
+			Column{baseType: "interval", typmod: 0x0004<<16 | 0xffff},
+			FullRewrite,
+		},
+	}
+	for _, test := range tests {
+		var impact Impact = test.current.typeChangeImpact(&test.wanted)
+		if impact != test.impact {
+			t.Logf("%s: expected %s, got %s", test.name, test.impact, impact)
+			t.Fail()
+		}
+	}
+}
+
+func TestShadowIndex(t *testing.T) {
+	var tests = []struct {
+		definition string
+		name       string
+		column     string
+		shadow     string
+	}{
+		{
+			"CREATE UNIQUE INDEX t_pkey ON public.t USING btree (id)",
+			"t_pkey",
+			"id",
+			"CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS \"t_pkey__new\" ON public.t USING btree (\"id__new\")",
+		},
+		{
+			"CREATE INDEX t_id_idx ON public.t USING btree (id, ids) INCLUDE (id) WHERE (id > 0)",
+			"t_id_idx",
+			"id",
+			"CREATE INDEX CONCURRENTLY IF NOT EXISTS \"t_id_idx__new\" ON public.t USING btree (\"id__new\", ids) " +
+				"INCLUDE (\"id__new\") WHERE (\"id__new\" > 0)",
+		},
+		{
+			"CREATE INDEX \"My index\" ON public.t USING btree (lower(id), (id + id)) WHERE (name <> 'id')",
+			"\"My index\"",
+			"id",
+			"CREATE INDEX CONCURRENTLY IF NOT EXISTS \"My index__new\" ON public.t USING btree " +
+				"(lower(\"id__new\"), (\"id__new\" + \"id__new\")) WHERE (name <> 'id')",
+		},
+		{
+			"CREATE INDEX t_key_idx ON public.t USING btree (\"Key\" DESC)",
+			"t_key_idx",
+			"\"Key\"",
+			"CREATE INDEX CONCURRENTLY IF NOT EXISTS \"t_key_idx__new\" ON public.t USING btree (\"id__new\" DESC)",
+		},
+	}
+	for _, test := range tests {
+		var index string = strings.Trim(test.name, "\"") + "__new"
+		var shadow string = shadowIndex(test.definition, test.name, test.column, index, "id__new")
+		if shadow != test.shadow {
+			t.Logf("expected %q, got %q", test.shadow, shadow)
+			t.Fail()
+		}
+	}
+}
+
+func TestForeignKeyActions(t *testing.T) {
+	var tests = []struct {
+		definition string
+		actions    string
+	}{
+		{"FOREIGN KEY (a) REFERENCES p(id)", ""},
+		{"FOREIGN KEY (a, b) REFERENCES p(x, y) MATCH FULL ON DELETE CASCADE", " MATCH FULL ON DELETE CASCADE"},
+		{"FOREIGN KEY (a) REFERENCES p(id) ON UPDATE SET NULL DEFERRABLE INITIALLY DEFERRED", " ON UPDATE SET NULL DEFERRABLE INITIALLY DEFERRED"},
+	}
+	for _, test := range tests {
+		if actions := foreignKeyActions(test.definition); actions != test.actions {
+			t.Logf("expected %q, got %q", test.actions, actions)
+			t.Fail()
+		}
+	}
+}
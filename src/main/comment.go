@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// quoteLiteral escapes the value as a string constant, backslashes force
+// an escape string so the result does not depend on standard_conforming_strings
+func quoteLiteral(value string) string {
+	value = strings.ReplaceAll(value, "'", "''")
+	if strings.Contains(value, "\\") {
+		return fmt.Sprintf("E'%s'", strings.ReplaceAll(value, "\\", "\\\\"))
+	}
+	return fmt.Sprintf("'%s'", value)
+}
+
+func commentStatement(object string, comment sql.NullString) string {
+	if !comment.Valid {
+		return fmt.Sprintf("COMMENT ON %s IS NULL;\n", object)
+	}
+	return fmt.Sprintf("COMMENT ON %s IS %s;\n", object, quoteLiteral(comment.String))
+}
+
+// commentDiff is empty when both comments match, objects missing from the
+// target are compared against a NULL comment
+func commentDiff(object string, source sql.NullString, target sql.NullString) string {
+	if source == target {
+		return ""
+	}
+	return commentStatement(object, source)
+}
+
+func (table *Table) commentDiff(target *Table) string {
+	var builder strings.Builder
+	if target == nil {
+		target = &Table{}
+	}
+	builder.WriteString(
+		commentDiff(fmt.Sprintf("%s \"%s\"", table.keyword(), table.name), table.comment, target.comment),
+	)
+	for _, column := range table.columns {
+		var current sql.NullString
+		if found := target.FindColumn(column); found != nil {
+			current = found.comment
+		}
+		builder.WriteString(
+			commentDiff(fmt.Sprintf("COLUMN \"%s\".\"%s\"", table.name, column.name), column.comment, current),
+		)
+	}
+	for _, constraint := range table.constraints {
+		var current sql.NullString
+		if found := target.FindConstraintByName(constraint.name); found != nil {
+			current = found.comment
+		}
+		builder.WriteString(
+			commentDiff(
+				fmt.Sprintf("CONSTRAINT \"%s\" ON \"%s\"", constraint.name, table.name),
+				constraint.comment,
+				current,
+			),
+		)
+	}
+	for _, index := range table.indexes {
+		var current sql.NullString
+		if found := target.FindIndex(index.name); found != nil {
+			current = found.comment
+		}
+		builder.WriteString(commentDiff(fmt.Sprintf("INDEX \"%s\"", index.name), index.comment, current))
+	}
+	for _, trigger := range table.triggers {
+		var current sql.NullString
+		if found := target.FindTrigger(trigger.name); found != nil {
+			current = found.comment
+		}
+		builder.WriteString(
+			commentDiff(fmt.Sprintf("TRIGGER \"%s\" ON \"%s\"", trigger.name, table.name), trigger.comment, current),
+		)
+	}
+	for _, policy := range table.policies {
+		var current sql.NullString
+		if found := target.FindPolicy(policy.name); found != nil {
+			current = found.comment
+		}
+		builder.WriteString(
+			commentDiff(fmt.Sprintf("POLICY \"%s\" ON \"%s\"", policy.name, table.name), policy.comment, current),
+		)
+	}
+	return builder.String()
+}
+
+// examineComments compares the comments of every object, the ones dropped
+// and created again by the migration have lost theirs
+func (schema *Schema) examineComments(target *Schema) (string, error) {
+	var builder strings.Builder
+	builder.WriteString(commentDiff(fmt.Sprintf("SCHEMA \"%s\"", schema.name), schema.comment, target.comment))
+	for _, collation := range schema.collations {
+		var current sql.NullString
+		if found := target.FindCollationByName(collation.name); found != nil {
+			current = found.comment
+		}
+		builder.WriteString(
+			commentDiff(fmt.Sprintf("COLLATION \"%s\"", collation.name), collation.comment, current),
+		)
+	}
+	for _, item := range schema.types {
+		var current sql.NullString
+		if found := target.FindTypeByName(item.name); found != nil && !found.recreated {
+			current = found.comment
+		}
+		builder.WriteString(commentDiff(fmt.Sprintf("%s \"%s\"", item.keyword(), item.name), item.comment, current))
+	}
+	for _, sequence := range schema.sequences {
+		var current sql.NullString
+		if found := target.FindSequence(sequence); found != nil {
+			current = found.comment
+		}
+		builder.WriteString(
+			commentDiff(fmt.Sprintf("SEQUENCE \"%s\"", sequence.name), sequence.comment, current),
+		)
+	}
+	for _, function := range schema.functions {
+		var current sql.NullString
+		if found := target.FindFunctionBySignature(function.Signature()); found != nil && !found.recreated {
+			current = found.comment
+		}
+		builder.WriteString(
+			commentDiff(fmt.Sprintf("%s %s", function.keyword(), function.Signature()), function.comment, current),
+		)
+	}
+	for _, table := range schema.tables {
+		var found *Table = target.FindTableByName(table.name)
+		if found != nil && found.recreated {
+			found = nil
+		}
+		builder.WriteString(table.commentDiff(found))
+	}
+	return builder.String(), nil
+}
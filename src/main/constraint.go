@@ -17,6 +17,11 @@ type Constraint struct {
 	foreignTable *Table
 	keys         []*Column
 	foreignKeys  []*Column
+	// False if the constraint is only inherited from a parent table
+	isLocal bool
+	comment sql.NullString
+	// As printed by pg_get_constraintdef(), check constraints use it
+	definition string
 }
 
 const GetConstraints string = `
@@ -26,7 +31,10 @@ SELECT
        btrim(conrelid::regclass::text, '"'), 
        btrim(confrelid::regclass::text, '"'), 
        conkey, 
-       confkey 
+       confkey,
+       conislocal,
+       obj_description(oid, 'pg_constraint'),
+       pg_get_constraintdef(oid)
 FROM pg_constraint 
 WHERE conrelid::regclass = quote_ident($1)::regclass
 `
@@ -94,6 +102,9 @@ func getConstraints(db *sql.DB, table Table, schema Schema) ([]*Constraint, erro
 			&foreignTableName,
 			&keys,
 			&foreignKeys,
+			&constraint.isLocal,
+			&constraint.comment,
+			&constraint.definition,
 		); err != nil {
 			return nil, err
 		}
@@ -204,6 +215,8 @@ func (constraint *Constraint) String() string {
 		)
 	case Unique:
 		return fmt.Sprintf("UNIQUE (\"%s\")", stringifyKeys(constraint.keys))
+	case Check:
+		return constraint.definition
 	}
 	return ""
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+const GetNormalizedDefault string = `
+SELECT pg_get_expr(adbin, adrelid)
+FROM pg_catalog.pg_attrdef
+WHERE adrelid = 'pg_temp.pg_diff_schema_default'::regclass
+`
+
+// normalizeDefault has the server parse the expression as the default of a
+// column of the given type and print it back, so that it can be compared
+// with defaults read from the catalog
+func normalizeDefault(tx *sql.Tx, dataType string, expression string) (string, error) {
+	var normalized string
+	var err error
+	if _, err = tx.Exec(
+		fmt.Sprintf("CREATE TEMPORARY TABLE pg_diff_schema_default (value %s DEFAULT %s)", dataType, expression),
+	); err != nil {
+		return "", err
+	}
+	if err = tx.QueryRow(GetNormalizedDefault).Scan(&normalized); err != nil {
+		return "", err
+	}
+	if _, err = tx.Exec("DROP TABLE pg_temp.pg_diff_schema_default"); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+// equalDefaults tells whether both defaults are the same, first as text
+// and then once normalized by the server; the expressions are never
+// evaluated, a default giving the same value right now is not the same
+// default
+func equalDefaults(db *sql.DB, dataType string, first string, second string) bool {
+	var tx *sql.Tx
+	var err error
+	var normalized string
+	if first == second {
+		return true
+	} else if db == nil {
+		return false
+	}
+	if tx, err = db.Begin(); err != nil {
+		log.Println(err)
+		return false
+	}
+	// Nothing done here has to be kept
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			log.Println(err)
+		}
+	}()
+	if normalized, err = normalizeDefault(tx, dataType, first); err != nil {
+		// Most likely the type does not exist yet in the target
+		return false
+	}
+	return normalized == second
+}
+
+// defaultDiff sets the default of the target column, or drops it, unless
+// both defaults are equivalent
+func (column *Column) defaultDiff(target *Column, migration *Migration) string {
+	var table *Table
+	var current string
+	var wanted string
+	var currentErr error
+	var wantedErr error
+	table = column.table
+	current, currentErr = column.GetDefaultValue()
+	wanted, wantedErr = target.GetDefaultValue()
+	if currentErr != nil && (wantedErr != nil || target.identity.Valid) {
+		return ""
+	} else if wantedErr != nil || target.identity.Valid {
+		// Identity columns cannot have a default
+		return table.annotate(
+			fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" DROP DEFAULT;\n", table.name, column.name),
+			AccessExclusive,
+			MetadataOnly,
+		)
+	} else if currentErr == nil && equalDefaults(migration.db, column.GetTypeString(), wanted, current) {
+		return ""
+	}
+	return table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET DEFAULT %s;\n", table.name, column.name, wanted),
+		AccessExclusive,
+		MetadataOnly,
+	)
+}
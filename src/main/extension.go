@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// GetExtensions lists the extensions of the whole database, they are not
+// bound to the schema being compared
+const GetExtensions string = `
+SELECT
+  e.extname,
+  n.nspname,
+  e.extversion,
+  e.extrelocatable
+FROM pg_catalog.pg_extension e
+JOIN pg_catalog.pg_namespace n ON n.oid = e.extnamespace
+ORDER BY e.extname
+`
+
+type Extension struct {
+	name        string
+	schema      string
+	version     string
+	relocatable bool
+}
+
+func (extension *Extension) collect(rows *sql.Rows) error {
+	return rows.Scan(
+		&extension.name,
+		&extension.schema,
+		&extension.version,
+		&extension.relocatable,
+	)
+}
+
+func (extension *Extension) CreateStatement() string {
+	return fmt.Sprintf(
+		"CREATE EXTENSION IF NOT EXISTS \"%s\" WITH SCHEMA \"%s\" VERSION %s;\n",
+		extension.name,
+		extension.schema,
+		quoteLiteral(extension.version),
+	)
+}
+
+func (extension *Extension) DropStatement() string {
+	return fmt.Sprintf("DROP EXTENSION IF EXISTS \"%s\";\n", extension.name)
+}
+
+func (extension *Extension) Diff(target *Extension) string {
+	var builder strings.Builder
+	if extension.schema != target.schema {
+		if target.relocatable {
+			builder.WriteString(
+				fmt.Sprintf("ALTER EXTENSION \"%s\" SET SCHEMA \"%s\";\n", extension.name, extension.schema),
+			)
+		} else {
+			builder.WriteString(
+				fmt.Sprintf(
+					"-- \033[31mWARNING\033[0m: extension \"%s\" is not relocatable, it has to be moved from \"%s\" to \"%s\" manually\n",
+					extension.name,
+					target.schema,
+					extension.schema,
+				),
+			)
+		}
+	}
+	if extension.version != target.version {
+		builder.WriteString(
+			fmt.Sprintf("ALTER EXTENSION \"%s\" UPDATE TO %s;\n", extension.name, quoteLiteral(extension.version)),
+		)
+	}
+	return builder.String()
+}
+
+func (schema *Schema) collectExtensions(db *sql.DB) error {
+	var rows *sql.Rows
+	var err error
+	if rows, err = db.Query(GetExtensions); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var extension Extension
+		if err = extension.collect(rows); err != nil {
+			return err
+		}
+		schema.extensions = append(schema.extensions, &extension)
+	}
+	return nil
+}
+
+func (schema *Schema) FindExtensionByName(name string) *Extension {
+	for _, extension := range schema.extensions {
+		if extension.name == name {
+			return extension
+		}
+	}
+	return nil
+}
+
+// examineExtensions runs before anything else, types and functions of the
+// extensions may be used by every other object
+func (schema *Schema) examineExtensions(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, extension := range schema.extensions {
+		var found *Extension
+		found = target.FindExtensionByName(extension.name)
+		if found == nil {
+			builder.WriteString(extension.CreateStatement())
+		} else {
+			builder.WriteString(extension.Diff(found))
+		}
+	}
+	return builder.String(), nil
+}
+
+func (schema *Schema) generateNeededDropExtensionStatements(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, extension := range target.extensions {
+		if schema.FindExtensionByName(extension.name) == nil {
+			builder.WriteString(extension.DropStatement())
+		}
+	}
+	return builder.String(), nil
+}
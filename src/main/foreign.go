@@ -0,0 +1,495 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// GetForeignDataWrappers skips the wrappers created by extensions, like
+// postgres_fdw, they come with the extension itself
+const GetForeignDataWrappers string = `
+SELECT
+  w.fdwname,
+  CASE WHEN w.fdwhandler <> 0 THEN w.fdwhandler::regproc::text END,
+  CASE WHEN w.fdwvalidator <> 0 THEN w.fdwvalidator::regproc::text END,
+  w.fdwoptions
+FROM pg_catalog.pg_foreign_data_wrapper w
+WHERE NOT EXISTS (
+  SELECT 1
+  FROM pg_catalog.pg_depend d
+  WHERE d.classid = 'pg_catalog.pg_foreign_data_wrapper'::regclass AND
+    d.objid = w.oid AND
+    d.deptype = 'e'
+)
+ORDER BY w.fdwname
+`
+
+const GetForeignServers string = `
+SELECT
+  s.srvname,
+  w.fdwname,
+  s.srvtype,
+  s.srvversion,
+  s.srvoptions
+FROM pg_catalog.pg_foreign_server s
+JOIN pg_catalog.pg_foreign_data_wrapper w ON w.oid = s.srvfdw
+WHERE NOT EXISTS (
+  SELECT 1
+  FROM pg_catalog.pg_depend d
+  WHERE d.classid = 'pg_catalog.pg_foreign_server'::regclass AND
+    d.objid = s.oid AND
+    d.deptype = 'e'
+)
+ORDER BY s.srvname
+`
+
+// GetUserMappings reads pg_user_mappings, the options are only visible
+// to the owner of the server or the mapped user, otherwise they are NULL
+// just like when there are none, so the same check is done here
+const GetUserMappings string = `
+SELECT
+  m.srvname,
+  CASE WHEN m.umuser <> 0 THEN m.usename END,
+  m.umoptions,
+  m.umoptions IS NOT NULL OR
+    (m.umuser <> 0 AND m.usename = current_user AND
+      (pg_has_role(s.srvowner, 'USAGE') OR has_server_privilege(s.oid, 'USAGE'))) OR
+    (m.umuser = 0 AND pg_has_role(s.srvowner, 'USAGE')) OR
+    (SELECT r.rolsuper FROM pg_catalog.pg_roles r WHERE r.rolname = current_user)
+FROM pg_catalog.pg_user_mappings m
+JOIN pg_catalog.pg_foreign_server s ON s.oid = m.srvid
+ORDER BY m.srvname, m.usename
+`
+
+// Options of user mappings that are never copied to the target
+var secretOptions []string = []string{"password", "passfile", "sslpassword", "sslkey", "sslcert"}
+
+type ForeignDataWrapper struct {
+	name      string
+	handler   sql.NullString
+	validator sql.NullString
+	options   stringArray
+}
+
+type ForeignServer struct {
+	name    string
+	wrapper string
+	kind    sql.NullString
+	version sql.NullString
+	options stringArray
+}
+
+type UserMapping struct {
+	server string
+	// Not set for the PUBLIC mapping
+	user    sql.NullString
+	options stringArray
+	// False when the options are hidden from the current user
+	readable bool
+	// True if a secret was removed from the options
+	secret bool
+}
+
+func optionValue(option string) string {
+	var parts []string = strings.SplitN(option, "=", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// genericOptions is the OPTIONS clause of foreign objects, the options
+// are stored as name=value like storage parameters
+func genericOptions(options []string) string {
+	var list []string
+	for _, option := range options {
+		list = append(list, fmt.Sprintf("%s %s", optionName(option), quoteLiteral(optionValue(option))))
+	}
+	return fmt.Sprintf("OPTIONS (%s)", strings.Join(list, ", "))
+}
+
+// genericOptionsDiff is the OPTIONS clause of ALTER turning the current
+// options into the wanted ones, empty if they are the same
+func genericOptionsDiff(wanted []string, current []string) string {
+	var list []string
+	var names []string
+	var currentNames []string
+	for _, option := range current {
+		currentNames = append(currentNames, optionName(option))
+	}
+	for _, option := range wanted {
+		var name string = optionName(option)
+		names = append(names, name)
+		if indexOf(current, option) != -1 {
+			continue
+		}
+		if indexOf(currentNames, name) != -1 {
+			list = append(list, fmt.Sprintf("SET %s %s", name, quoteLiteral(optionValue(option))))
+		} else {
+			list = append(list, fmt.Sprintf("ADD %s %s", name, quoteLiteral(optionValue(option))))
+		}
+	}
+	for _, name := range currentNames {
+		if indexOf(names, name) == -1 {
+			list = append(list, fmt.Sprintf("DROP %s", name))
+		}
+	}
+	if len(list) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("OPTIONS (%s)", strings.Join(list, ", "))
+}
+
+func (wrapper *ForeignDataWrapper) CreateStatement() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("CREATE FOREIGN DATA WRAPPER \"%s\"", wrapper.name))
+	if wrapper.handler.Valid {
+		builder.WriteString(fmt.Sprintf(" HANDLER %s", wrapper.handler.String))
+	}
+	if wrapper.validator.Valid {
+		builder.WriteString(fmt.Sprintf(" VALIDATOR %s", wrapper.validator.String))
+	}
+	if len(wrapper.options) > 0 {
+		builder.WriteString(fmt.Sprintf(" %s", genericOptions(wrapper.options)))
+	}
+	builder.WriteString(";\n")
+	return builder.String()
+}
+
+func (wrapper *ForeignDataWrapper) DropStatement() string {
+	return fmt.Sprintf("DROP FOREIGN DATA WRAPPER IF EXISTS \"%s\";\n", wrapper.name)
+}
+
+func (wrapper *ForeignDataWrapper) Diff(target *ForeignDataWrapper) string {
+	var list []string
+	var options string
+	if wrapper.handler != target.handler {
+		if wrapper.handler.Valid {
+			list = append(list, fmt.Sprintf("HANDLER %s", wrapper.handler.String))
+		} else {
+			list = append(list, "NO HANDLER")
+		}
+	}
+	if wrapper.validator != target.validator {
+		if wrapper.validator.Valid {
+			list = append(list, fmt.Sprintf("VALIDATOR %s", wrapper.validator.String))
+		} else {
+			list = append(list, "NO VALIDATOR")
+		}
+	}
+	if options = genericOptionsDiff(wrapper.options, target.options); options != "" {
+		list = append(list, options)
+	}
+	if len(list) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("ALTER FOREIGN DATA WRAPPER \"%s\" %s;\n", wrapper.name, strings.Join(list, " "))
+}
+
+func (server *ForeignServer) CreateStatement() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("CREATE SERVER IF NOT EXISTS \"%s\"", server.name))
+	if server.kind.Valid {
+		builder.WriteString(fmt.Sprintf(" TYPE %s", quoteLiteral(server.kind.String)))
+	}
+	if server.version.Valid {
+		builder.WriteString(fmt.Sprintf(" VERSION %s", quoteLiteral(server.version.String)))
+	}
+	builder.WriteString(fmt.Sprintf(" FOREIGN DATA WRAPPER \"%s\"", server.wrapper))
+	if len(server.options) > 0 {
+		builder.WriteString(fmt.Sprintf(" %s", genericOptions(server.options)))
+	}
+	builder.WriteString(";\n")
+	return builder.String()
+}
+
+func (server *ForeignServer) DropStatement() string {
+	return fmt.Sprintf("DROP SERVER IF EXISTS \"%s\";\n", server.name)
+}
+
+// Diff alters the server, neither the wrapper nor the type of a server
+// can be changed without dropping every foreign table using it
+func (server *ForeignServer) Diff(target *ForeignServer) string {
+	var builder strings.Builder
+	var list []string
+	var options string
+	if server.wrapper != target.wrapper || server.kind != target.kind {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: server \"%s\" uses a different wrapper or type, it has to be recreated manually\n",
+				server.name,
+			),
+		)
+	}
+	if server.version != target.version {
+		if server.version.Valid {
+			list = append(list, fmt.Sprintf("VERSION %s", quoteLiteral(server.version.String)))
+		} else {
+			list = append(list, "VERSION NULL")
+		}
+	}
+	if options = genericOptionsDiff(server.options, target.options); options != "" {
+		list = append(list, options)
+	}
+	if len(list) > 0 {
+		builder.WriteString(fmt.Sprintf("ALTER SERVER \"%s\" %s;\n", server.name, strings.Join(list, " ")))
+	}
+	return builder.String()
+}
+
+func (mapping *UserMapping) role() string {
+	if !mapping.user.Valid {
+		return "PUBLIC"
+	}
+	return fmt.Sprintf("\"%s\"", mapping.user.String)
+}
+
+func (mapping *UserMapping) key() string {
+	return fmt.Sprintf("%s/%s", mapping.server, mapping.user.String)
+}
+
+func (mapping *UserMapping) CreateStatement() string {
+	var builder strings.Builder
+	if mapping.secret {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: user mapping for %s on server \"%s\" has credentials, they have to be set manually\n",
+				mapping.role(),
+				mapping.server,
+			),
+		)
+	}
+	if !mapping.readable {
+		builder.WriteString(mapping.unreadableWarning())
+	}
+	builder.WriteString(fmt.Sprintf("CREATE USER MAPPING IF NOT EXISTS FOR %s SERVER \"%s\"", mapping.role(), mapping.server))
+	if len(mapping.options) > 0 {
+		builder.WriteString(fmt.Sprintf(" %s", genericOptions(mapping.options)))
+	}
+	builder.WriteString(";\n")
+	return builder.String()
+}
+
+func (mapping *UserMapping) DropStatement() string {
+	return fmt.Sprintf("DROP USER MAPPING IF EXISTS FOR %s SERVER \"%s\";\n", mapping.role(), mapping.server)
+}
+
+func (mapping *UserMapping) unreadableWarning() string {
+	return fmt.Sprintf(
+		"-- \033[31mWARNING\033[0m: options of the user mapping for %s on server \"%s\" are not readable, they have to be compared manually\n",
+		mapping.role(),
+		mapping.server,
+	)
+}
+
+func (mapping *UserMapping) Diff(target *UserMapping) string {
+	var options string
+	if !mapping.readable || !target.readable {
+		return mapping.unreadableWarning()
+	}
+	if options = genericOptionsDiff(mapping.options, target.options); options == "" {
+		return ""
+	}
+	return fmt.Sprintf("ALTER USER MAPPING FOR %s SERVER \"%s\" %s;\n", mapping.role(), mapping.server, options)
+}
+
+// removeSecrets drops the options holding credentials, they are neither
+// compared nor copied
+func (mapping *UserMapping) removeSecrets() {
+	var options stringArray
+	for _, option := range mapping.options {
+		if indexOf(secretOptions, optionName(option)) != -1 {
+			mapping.secret = true
+			continue
+		}
+		options = append(options, option)
+	}
+	mapping.options = options
+}
+
+// serverClause is the SERVER and OPTIONS part of CREATE FOREIGN TABLE
+func (table *Table) serverClause() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf(" SERVER \"%s\"", table.server.String))
+	if len(table.foreignOptions) > 0 {
+		builder.WriteString(fmt.Sprintf(" %s", genericOptions(table.foreignOptions)))
+	}
+	return builder.String()
+}
+
+func (table *Table) CreateForeignTableStatement() string {
+	var builder strings.Builder
+	var list []string
+	for _, column := range table.columns {
+		if column.isLocal {
+			list = append(list, column.String())
+		}
+	}
+	for _, constraint := range table.constraints {
+		if constraint.isLocal {
+			list = append(list, constraint.String())
+		}
+	}
+	builder.WriteString(
+		fmt.Sprintf("CREATE FOREIGN TABLE \"%s\" (\n  %s\n)", table.name, strings.Join(list, ",\n  ")),
+	)
+	if len(table.inherits) > 0 {
+		builder.WriteString(fmt.Sprintf(" INHERITS (\"%s\")", strings.Join(table.inherits, "\", \"")))
+	}
+	builder.WriteString(table.serverClause())
+	builder.WriteString(";\n")
+	for _, trigger := range table.triggers {
+		builder.WriteString(trigger.CreateStatement())
+	}
+	return builder.String()
+}
+
+// foreignTableDiff compares the options of the foreign tables and their
+// columns, a change of server is handled by recreating the table
+func (table *Table) foreignTableDiff(target *Table) string {
+	var builder strings.Builder
+	var options string
+	if options = genericOptionsDiff(table.foreignOptions, target.foreignOptions); options != "" {
+		builder.WriteString(target.annotate(
+			fmt.Sprintf("ALTER FOREIGN TABLE \"%s\" %s;\n", table.name, options),
+			AccessExclusive,
+			MetadataOnly,
+		))
+	}
+	for _, column := range table.columns {
+		var found *Column
+		if found = target.FindColumn(column); found == nil {
+			continue
+		}
+		if options = genericOptionsDiff(column.fdwOptions, found.fdwOptions); options != "" {
+			builder.WriteString(target.annotate(
+				fmt.Sprintf("ALTER FOREIGN TABLE \"%s\" ALTER COLUMN \"%s\" %s;\n", table.name, column.name, options),
+				AccessExclusive,
+				MetadataOnly,
+			))
+		}
+	}
+	return builder.String()
+}
+
+func (schema *Schema) collectForeignObjects(db *sql.DB) error {
+	var rows *sql.Rows
+	var err error
+	if rows, err = db.Query(GetForeignDataWrappers); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var wrapper ForeignDataWrapper
+		if err = rows.Scan(&wrapper.name, &wrapper.handler, &wrapper.validator, &wrapper.options); err != nil {
+			return err
+		}
+		schema.wrappers = append(schema.wrappers, &wrapper)
+	}
+	if rows, err = db.Query(GetForeignServers); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var server ForeignServer
+		if err = rows.Scan(
+			&server.name,
+			&server.wrapper,
+			&server.kind,
+			&server.version,
+			&server.options,
+		); err != nil {
+			return err
+		}
+		schema.servers = append(schema.servers, &server)
+	}
+	if rows, err = db.Query(GetUserMappings); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var mapping UserMapping
+		if err = rows.Scan(&mapping.server, &mapping.user, &mapping.options, &mapping.readable); err != nil {
+			return err
+		}
+		mapping.removeSecrets()
+		schema.userMappings = append(schema.userMappings, &mapping)
+	}
+	return nil
+}
+
+func (schema *Schema) FindForeignDataWrapperByName(name string) *ForeignDataWrapper {
+	for _, wrapper := range schema.wrappers {
+		if wrapper.name == name {
+			return wrapper
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) FindForeignServerByName(name string) *ForeignServer {
+	for _, server := range schema.servers {
+		if server.name == name {
+			return server
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) FindUserMapping(key string) *UserMapping {
+	for _, mapping := range schema.userMappings {
+		if mapping.key() == key {
+			return mapping
+		}
+	}
+	return nil
+}
+
+// examineForeignServers creates or alters wrappers, servers and user
+// mappings, foreign tables need them to exist
+func (schema *Schema) examineForeignServers(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, wrapper := range schema.wrappers {
+		var found *ForeignDataWrapper
+		if found = target.FindForeignDataWrapperByName(wrapper.name); found == nil {
+			builder.WriteString(wrapper.CreateStatement())
+		} else {
+			builder.WriteString(wrapper.Diff(found))
+		}
+	}
+	for _, server := range schema.servers {
+		var found *ForeignServer
+		if found = target.FindForeignServerByName(server.name); found == nil {
+			builder.WriteString(server.CreateStatement())
+		} else {
+			builder.WriteString(server.Diff(found))
+		}
+	}
+	for _, mapping := range schema.userMappings {
+		var found *UserMapping
+		if found = target.FindUserMapping(mapping.key()); found == nil {
+			builder.WriteString(mapping.CreateStatement())
+		} else {
+			builder.WriteString(mapping.Diff(found))
+		}
+	}
+	return builder.String(), nil
+}
+
+func (schema *Schema) generateNeededDropForeignServerStatements(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, mapping := range target.userMappings {
+		if schema.FindUserMapping(mapping.key()) == nil {
+			builder.WriteString(mapping.DropStatement())
+		}
+	}
+	for _, server := range target.servers {
+		if schema.FindForeignServerByName(server.name) == nil {
+			builder.WriteString(server.DropStatement())
+		}
+	}
+	for _, wrapper := range target.wrappers {
+		if schema.FindForeignDataWrapperByName(wrapper.name) == nil {
+			builder.WriteString(wrapper.DropStatement())
+		}
+	}
+	return builder.String(), nil
+}
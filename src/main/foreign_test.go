@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestGenericOptionsDiff(t *testing.T) {
+	var tests = []struct {
+		name    string
+		wanted  []string
+		current []string
+		clause  string
+	}{
+		{"same", []string{"host=db", "port=5432"}, []string{"host=db", "port=5432"}, ""},
+		{"added", []string{"host=db", "port=5432"}, []string{"host=db"}, "OPTIONS (ADD port '5432')"},
+		{"changed", []string{"host=db2"}, []string{"host=db"}, "OPTIONS (SET host 'db2')"},
+		{"dropped", []string{"host=db"}, []string{"host=db", "port=5432"}, "OPTIONS (DROP port)"},
+		{"quoted", []string{"filename=/tmp/it's"}, nil, "OPTIONS (ADD filename '/tmp/it''s')"},
+		{"equal sign in value", []string{"query=a=b"}, []string{"query=a"}, "OPTIONS (SET query 'a=b')"},
+		{
+			"mixed",
+			[]string{"host=db2", "dbname=app"},
+			[]string{"host=db", "port=5432"},
+			"OPTIONS (SET host 'db2', ADD dbname 'app', DROP port)",
+		},
+	}
+	for _, test := range tests {
+		var clause string = genericOptionsDiff(test.wanted, test.current)
+		if clause != test.clause {
+			t.Logf("%s: expected %q, got %q", test.name, test.clause, clause)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// GetFunctions skips the functions created by extensions and the ones
+// created along with another object, like the constructors of range types
+const GetFunctions string = `
+SELECT
+  p.proname,
+  oidvectortypes(p.proargtypes),
+  pg_get_function_arguments(p.oid),
+  pg_get_function_result(p.oid),
+  p.prokind,
+  l.lanname,
+  p.provolatile,
+  p.prosecdef,
+  p.proisstrict,
+  p.proparallel,
+  p.proconfig,
+  pg_get_functiondef(p.oid),
+  COALESCE(p.proacl, acldefault('f', p.proowner)),
+  pg_get_userbyid(p.proowner),
+  obj_description(p.oid, 'pg_proc')
+FROM pg_catalog.pg_proc p
+JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+JOIN pg_catalog.pg_language l ON l.oid = p.prolang
+WHERE n.nspname = $1 AND
+  p.prokind IN ('f', 'p') AND
+  NOT EXISTS (
+    SELECT 1
+    FROM pg_catalog.pg_depend d
+    WHERE d.classid = 'pg_catalog.pg_proc'::regclass AND
+      d.objid = p.oid AND
+      d.deptype IN ('e', 'i')
+  )
+ORDER BY p.proname, 2
+`
+
+type FunctionKind string
+
+const (
+	PlainFunction FunctionKind = "f"
+	Procedure                  = "p"
+)
+
+type Function struct {
+	name string
+	// Types of the arguments, they tell overloads apart
+	arguments string
+	// Full list of parameters, with their names and defaults
+	parameters      string
+	result          sql.NullString
+	kind            FunctionKind
+	language        string
+	volatility      string
+	securityDefiner bool
+	strict          bool
+	parallel        string
+	config          stringArray
+	definition      string
+	acl             aclArray
+	owner           string
+	comment         sql.NullString
+	// Set on functions of the target dropped and created again, they come
+	// back with the privileges, owner and comment of a new function
+	recreated bool
+}
+
+func (function *Function) collect(rows *sql.Rows) error {
+	return rows.Scan(
+		&function.name,
+		&function.arguments,
+		&function.parameters,
+		&function.result,
+		&function.kind,
+		&function.language,
+		&function.volatility,
+		&function.securityDefiner,
+		&function.strict,
+		&function.parallel,
+		&function.config,
+		&function.definition,
+		&function.acl,
+		&function.owner,
+		&function.comment,
+	)
+}
+
+// Signature identifies the function among its overloads, it is the name
+// followed by the argument types
+func (function *Function) Signature() string {
+	return fmt.Sprintf("\"%s\"(%s)", function.name, function.arguments)
+}
+
+func (function *Function) keyword() string {
+	if function.kind == Procedure {
+		return "PROCEDURE"
+	}
+	return "FUNCTION"
+}
+
+func (function *Function) CreateStatement() string {
+	// pg_get_functiondef() already generates CREATE OR REPLACE
+	return fmt.Sprintf("%s;\n", strings.TrimRight(function.definition, "\n"))
+}
+
+func (function *Function) DropStatement() string {
+	return fmt.Sprintf("DROP %s IF EXISTS %s;\n", function.keyword(), function.Signature())
+}
+
+// changes lists what differs between both functions, it is empty if
+// they are the same
+func (function *Function) changes(target *Function) []string {
+	var list []string
+	if function.kind != target.kind {
+		list = append(list, "kind")
+	}
+	if function.result != target.result {
+		list = append(list, "result")
+	}
+	if function.parameters != target.parameters {
+		list = append(list, "parameters")
+	}
+	if function.language != target.language {
+		list = append(list, "language")
+	}
+	if function.volatility != target.volatility {
+		list = append(list, "volatility")
+	}
+	if function.securityDefiner != target.securityDefiner {
+		list = append(list, "security definer")
+	}
+	if function.strict != target.strict {
+		list = append(list, "strictness")
+	}
+	if function.parallel != target.parallel {
+		list = append(list, "parallel safety")
+	}
+	if strings.Join(function.config, ",") != strings.Join(target.config, ",") {
+		list = append(list, "configuration")
+	}
+	if len(list) == 0 && function.definition != target.definition {
+		list = append(list, "body")
+	}
+	return list
+}
+
+func (function *Function) Diff(target *Function) string {
+	var builder strings.Builder
+	var changes []string
+	changes = function.changes(target)
+	if len(changes) == 0 {
+		return ""
+	}
+	builder.WriteString(
+		fmt.Sprintf("-- %s %s changed: %s\n", strings.ToLower(function.keyword()), function.Signature(), strings.Join(changes, ", ")),
+	)
+	if function.kind != target.kind || function.result != target.result || function.parameters != target.parameters {
+		// CREATE OR REPLACE can change neither the return type nor the
+		// names and defaults of the parameters
+		builder.WriteString(target.DropStatement())
+		target.recreated = true
+	}
+	builder.WriteString(function.CreateStatement())
+	return builder.String()
+}
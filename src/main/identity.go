@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (column *Column) identityOptions() []string {
+	var list []string
+	if column.identityStart.Valid {
+		list = append(list, fmt.Sprintf("START WITH %s", column.identityStart.String))
+	}
+	if column.identityIncrement.Valid {
+		list = append(list, fmt.Sprintf("INCREMENT BY %s", column.identityIncrement.String))
+	}
+	if column.identityMinimum.Valid {
+		list = append(list, fmt.Sprintf("MINVALUE %s", column.identityMinimum.String))
+	}
+	if column.identityMaximum.Valid {
+		list = append(list, fmt.Sprintf("MAXVALUE %s", column.identityMaximum.String))
+	}
+	if column.identityCycle.Valid && column.identityCycle.Bool {
+		list = append(list, "CYCLE")
+	} else {
+		list = append(list, "NO CYCLE")
+	}
+	return list
+}
+
+func (column *Column) identityClause() string {
+	return fmt.Sprintf(
+		" GENERATED %s AS IDENTITY (%s)",
+		column.identity.String,
+		strings.Join(column.identityOptions(), " "),
+	)
+}
+
+// identityDiff turns the target column into an identity column, or back
+// into a plain column, with the same generation and sequence options
+func (column *Column) identityDiff(target *Column) string {
+	var table *Table
+	var statement string
+	table = column.table
+	if !column.identity.Valid && !target.identity.Valid {
+		return ""
+	} else if !target.identity.Valid {
+		statement = fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" DROP IDENTITY IF EXISTS;\n", table.name, column.name)
+	} else if !column.identity.Valid {
+		statement = fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" ADD%s;\n", table.name, column.name, target.identityClause())
+	} else if column.identity != target.identity ||
+		strings.Join(column.identityOptions(), " ") != strings.Join(target.identityOptions(), " ") {
+		// Every sequence option needs its own SET, the current value of
+		// the sequence is left alone
+		statement = fmt.Sprintf(
+			"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET GENERATED %s SET %s;\n",
+			table.name,
+			column.name,
+			target.identity.String,
+			strings.Join(target.identityOptions(), " SET "),
+		)
+	} else {
+		return ""
+	}
+	return table.annotate(statement, AccessExclusive, MetadataOnly)
+}
+
+// generationDiff handles changes of the expression of a generated column,
+// the expression of an existing column cannot be changed so the column has
+// to be added again; true is returned in that case as any other change to
+// the column is then meaningless
+func (column *Column) generationDiff(target *Column) (bool, string) {
+	var table *Table
+	var builder strings.Builder
+	table = column.table
+	if column.generationExpression == target.generationExpression {
+		return false, ""
+	} else if !target.generationExpression.Valid {
+		// The values stay, it simply becomes a regular column
+		return false, table.annotate(
+			fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" DROP EXPRESSION IF EXISTS;\n", table.name, column.name),
+			AccessExclusive,
+			MetadataOnly,
+		)
+	}
+	builder.WriteString(table.DropColumnStatement(column))
+	builder.WriteString(table.AddColumnStatement(target))
+	return true, builder.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestIdentityDiff(t *testing.T) {
+	var table *Table = &Table{name: "t"}
+	var always sql.NullString = sql.NullString{String: "ALWAYS", Valid: true}
+	var byDefault sql.NullString = sql.NullString{String: "BY DEFAULT", Valid: true}
+	var start sql.NullString = sql.NullString{String: "100", Valid: true}
+	var tests = []struct {
+		name      string
+		current   Column
+		wanted    Column
+		statement string
+	}{
+		{
+			name:    "none",
+			current: Column{name: "id", table: table},
+			wanted:  Column{name: "id", table: table},
+		},
+		{
+			name:      "added",
+			current:   Column{name: "id", table: table},
+			wanted:    Column{name: "id", table: table, identity: always},
+			statement: "ALTER TABLE \"t\" ALTER COLUMN \"id\" ADD GENERATED ALWAYS AS IDENTITY (NO CYCLE);\n",
+		},
+		{
+			name:      "added with options",
+			current:   Column{name: "id", table: table},
+			wanted:    Column{name: "id", table: table, identity: byDefault, identityStart: start},
+			statement: "ALTER TABLE \"t\" ALTER COLUMN \"id\" ADD GENERATED BY DEFAULT AS IDENTITY (START WITH 100 NO CYCLE);\n",
+		},
+		{
+			name:      "dropped",
+			current:   Column{name: "id", table: table, identity: always},
+			wanted:    Column{name: "id", table: table},
+			statement: "ALTER TABLE \"t\" ALTER COLUMN \"id\" DROP IDENTITY IF EXISTS;\n",
+		},
+		{
+			name:      "generation changed",
+			current:   Column{name: "id", table: table, identity: always},
+			wanted:    Column{name: "id", table: table, identity: byDefault},
+			statement: "ALTER TABLE \"t\" ALTER COLUMN \"id\" SET GENERATED BY DEFAULT SET NO CYCLE;\n",
+		},
+		{
+			name:      "options changed",
+			current:   Column{name: "id", table: table, identity: always},
+			wanted:    Column{name: "id", table: table, identity: always, identityStart: start},
+			statement: "ALTER TABLE \"t\" ALTER COLUMN \"id\" SET GENERATED ALWAYS SET START WITH 100 SET NO CYCLE;\n",
+		},
+		{
+			name:    "same",
+			current: Column{name: "id", table: table, identity: always, identityStart: start},
+			wanted:  Column{name: "id", table: table, identity: always, identityStart: start},
+		},
+	}
+	for _, test := range tests {
+		var statement string = test.current.identityDiff(&test.wanted)
+		var expected string
+		if test.statement != "" {
+			expected = table.annotate(test.statement, AccessExclusive, MetadataOnly)
+		}
+		if statement != expected {
+			t.Logf("%s: expected %q, got %q", test.name, expected, statement)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const GetIndexes string = `
+SELECT
+  c.relname,
+  pg_get_indexdef(i.indexrelid),
+  obj_description(i.indexrelid, 'pg_class')
+FROM pg_catalog.pg_index i
+JOIN pg_catalog.pg_class c ON c.oid = i.indexrelid
+WHERE i.indrelid = quote_ident($1)::regclass
+ORDER BY c.relname
+`
+
+type Index struct {
+	name       string
+	definition string
+	comment    sql.NullString
+}
+
+func getIndexes(db *sql.DB, table *Table) ([]*Index, error) {
+	var rows *sql.Rows
+	var indexes []*Index
+	var err error
+	if rows, err = db.Query(GetIndexes, table.name); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var index Index
+		if err = rows.Scan(&index.name, &index.definition, &index.comment); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, &index)
+	}
+	return indexes, nil
+}
+
+func (index *Index) CreateStatement() string {
+	return fmt.Sprintf("%s;\n", index.definition)
+}
+
+func (index *Index) DropStatement() string {
+	return fmt.Sprintf("DROP INDEX IF EXISTS \"%s\";\n", index.name)
+}
+
+func (table *Table) FindIndex(name string) *Index {
+	for _, index := range table.indexes {
+		if index.name == name {
+			return index
+		}
+	}
+	return nil
+}
+
+func (table *Table) indexDiff(target *Table) string {
+	var builder strings.Builder
+	for _, index := range target.indexes {
+		var found *Index
+		found = table.FindIndex(index.name)
+		if found == nil || found.definition != index.definition {
+			builder.WriteString(target.annotate(index.DropStatement(), AccessExclusive, MetadataOnly))
+		}
+	}
+	for _, index := range table.indexes {
+		var found *Index
+		found = target.FindIndex(index.name)
+		if found == nil || found.definition != index.definition {
+			// Building the index blocks writes but not reads
+			builder.WriteString(target.annotate(index.CreateStatement(), Share, FullScan))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parents lists the tables this table inherits from, be it as a partition
+// or with INHERITS
+func (table *Table) parents() []string {
+	if table.partitionOf.Valid {
+		return []string{table.partitionOf.String}
+	}
+	return table.inherits
+}
+
+// inheritanceLevel is 0 for tables without a parent, and the depth in the
+// inheritance tree otherwise
+func (schema *Schema) inheritanceLevel(table *Table) int {
+	var level int
+	for _, name := range table.parents() {
+		var parent *Table
+		parent = schema.FindTableByName(name)
+		if parent == nil {
+			continue
+		}
+		if depth := schema.inheritanceLevel(parent) + 1; depth > level {
+			level = depth
+		}
+	}
+	return level
+}
+
+// inheritanceDiff makes the target inherit from the same parents as this
+// table, inherited columns and constraints then follow the parents
+func (table *Table) inheritanceDiff(target *Table) string {
+	var builder strings.Builder
+	var attaching bool
+	for _, parent := range table.inherits {
+		if indexOf(target.inherits, parent) == -1 {
+			attaching = true
+		}
+	}
+	if attaching {
+		// INHERIT fails unless the table has every column and check
+		// constraint of the parent
+		for _, column := range table.columns {
+			if !column.isLocal && target.FindColumn(column) == nil {
+				builder.WriteString(target.AddColumnStatement(column))
+			}
+		}
+		for _, constraint := range table.constraints {
+			if !constraint.isLocal && constraint.kind == Check && target.FindConstraintByName(constraint.name) == nil {
+				builder.WriteString(target.AddConstraintStatement(constraint))
+			}
+		}
+	}
+	for _, parent := range table.inherits {
+		if indexOf(target.inherits, parent) == -1 {
+			// The constraints of the parent must already be on the table,
+			// the rows are not checked again
+			builder.WriteString(target.annotate(
+				fmt.Sprintf("ALTER TABLE \"%s\" INHERIT \"%s\";\n", table.name, parent),
+				AccessExclusive,
+				MetadataOnly,
+			))
+		}
+	}
+	for _, parent := range target.inherits {
+		if indexOf(table.inherits, parent) == -1 {
+			builder.WriteString(target.annotate(
+				fmt.Sprintf("ALTER TABLE \"%s\" NO INHERIT \"%s\";\n", table.name, parent),
+				AccessExclusive,
+				MetadataOnly,
+			))
+		}
+	}
+	return builder.String()
+}
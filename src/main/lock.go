@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type LockMode string
+
+const (
+	AccessExclusive      LockMode = "ACCESS EXCLUSIVE"
+	ShareRowExclusive    LockMode = "SHARE ROW EXCLUSIVE"
+	ShareUpdateExclusive LockMode = "SHARE UPDATE EXCLUSIVE"
+	Share                LockMode = "SHARE"
+)
+
+type Impact int
+
+const (
+	// Only the catalog is touched, the statement is instant once the
+	// lock is granted
+	MetadataOnly Impact = iota
+	// Every row is read while the lock is held
+	FullScan
+	// The whole table (and its indexes) is written again
+	FullRewrite
+)
+
+func (impact Impact) String() string {
+	switch impact {
+	case FullScan:
+		return "full table scan"
+	case FullRewrite:
+		return "full table rewrite"
+	}
+	return "metadata only"
+}
+
+func prettySize(size int64) string {
+	var units []string = []string{"bytes", "kB", "MB", "GB", "TB"}
+	var index int
+	for index = 0; size >= 10*1024 && index < len(units)-1; index++ {
+		size /= 1024
+	}
+	return fmt.Sprintf("%d %s", size, units[index])
+}
+
+// annotate prefixes the statement with a comment describing the lock it
+// takes on the table and whether it needs to scan or rewrite it, so that
+// risky statements can be scheduled in a maintenance window
+func (table *Table) annotate(statement string, mode LockMode, impact Impact) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("-- lock: %s on \"%s\", %s", mode, table.name, impact))
+	if impact != MetadataOnly {
+		builder.WriteString(
+			fmt.Sprintf(" (~%d rows, %s)", table.estimatedRows, prettySize(table.size)),
+		)
+	}
+	builder.WriteString("\n")
+	builder.WriteString(statement)
+	return builder.String()
+}
+
+// annotateReferenced adds the lock a foreign key takes on the table it
+// references, which is not scanned, to the annotation of the statement
+func (constraint *Constraint) annotateReferenced(statement string) string {
+	var referenced *Table = constraint.foreignTable
+	if constraint.kind != ForeignKey || referenced == nil || referenced == constraint.table {
+		return statement
+	}
+	return referenced.annotate(statement, ShareRowExclusive, MetadataOnly)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -13,28 +14,50 @@ func main() {
 	var err error
 	var source *Schema
 	var target *Schema
-	var sql string
+	var migration *Migration
+	var options Options
 	var file *os.File
 
-	source, err = NewSchema("localhost", 5432, "postgres", "", os.Args[1])
-	if err != nil {
-		panic(err)
-	}
+	flag.BoolVar(&options.online, "online", false, "split locking changes across transactions to avoid downtime")
+	flag.IntVar(&options.batchSize, "batch-size", 10000, "rows per transaction when backfilling in online mode")
+	flag.BoolVar(&options.syncSequences, "sync-sequences", false, "copy the current value of sequences to the target")
+	flag.Var(&options.roles, "map-role", "source=target role name mapping, can be repeated")
+	flag.Parse()
 
-	target, err = NewSchema("localhost", 5432, "postgres", "", os.Args[2])
+	source, err = NewSchema("localhost", 5432, "postgres", "", flag.Arg(0))
 	if err != nil {
 		panic(err)
 	}
+	defer source.Close()
 
-	sql, err = source.Diff(target)
+	target, err = NewSchema("localhost", 5432, "postgres", "", flag.Arg(1))
 	if err != nil {
 		panic(err)
 	}
+	defer target.Close()
 
-	file, err = os.Create("migrate.sql")
+	migration = NewMigration(options)
+	err = source.Diff(target, migration)
 	if err != nil {
 		panic(err)
 	}
 
-	_, _ = file.WriteString(fmt.Sprintf("SET client_min_messages TO WARNING;\nBEGIN;\n%sROLLBACK;\n\n", sql))
+	for _, phase := range Phases {
+		var sql string = migration.Script(phase)
+		if phase != Migrate && sql == "" {
+			continue
+		}
+
+		file, err = os.Create(phase.FileName())
+		if err != nil {
+			panic(err)
+		}
+
+		if phase.Transactional() {
+			_, _ = file.WriteString(fmt.Sprintf("SET client_min_messages TO WARNING;\nSET check_function_bodies = false;\nBEGIN;\n%sROLLBACK;\n\n", sql))
+		} else {
+			_, _ = file.WriteString(fmt.Sprintf("SET client_min_messages TO WARNING;\n%s\n", sql))
+		}
+		_ = file.Close()
+	}
 }
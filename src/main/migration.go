@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type Phase int
+
+const (
+	// Everything that can run in a single transaction right away
+	Migrate Phase = iota
+	// Batched data copies, every batch is committed on its own so this
+	// phase cannot run inside a transaction block
+	Backfill
+	// Validation of constraints added as NOT VALID, it only takes a
+	// SHARE UPDATE EXCLUSIVE lock so it does not block writes
+	Validate
+	// Statements that depend on the validation, e.g. SET NOT NULL once an
+	// equivalent CHECK constraint has been validated
+	Contract
+	// Statements that have to be committed before the migration runs,
+	// e.g. new enum labels cannot be used in the transaction adding them
+	Prepare
+)
+
+var Phases []Phase = []Phase{Prepare, Migrate, Backfill, Validate, Contract}
+
+func (phase Phase) String() string {
+	switch phase {
+	case Backfill:
+		return "backfill"
+	case Validate:
+		return "validate"
+	case Contract:
+		return "contract"
+	case Prepare:
+		return "prepare"
+	}
+	return "migrate"
+}
+
+func (phase Phase) FileName() string {
+	if phase == Migrate {
+		return "migrate.sql"
+	}
+	if phase == Prepare {
+		// It runs first
+		return "migrate-0-prepare.sql"
+	}
+	return fmt.Sprintf("migrate-%d-%s.sql", phase, phase)
+}
+
+func (phase Phase) Transactional() bool {
+	return phase != Backfill && phase != Prepare
+}
+
+type Options struct {
+	// Prefer statements that avoid long exclusive locks, splitting them
+	// across several transactions if needed
+	online bool
+	// Number of rows updated per transaction when backfilling
+	batchSize int
+	// Copy the current value of every sequence with setval()
+	syncSequences bool
+	// Role names of the source replaced by the ones used in the target
+	roles RoleMap
+}
+
+type Migration struct {
+	options Options
+	phases  map[Phase]*strings.Builder
+	// Connection to the database being migrated, used to compare defaults
+	// the way the server understands them and to read the views, indexes
+	// and constraints using a column whose type changes
+	db *sql.DB
+}
+
+func NewMigration(options Options) *Migration {
+	return &Migration{
+		options: options,
+		phases:  make(map[Phase]*strings.Builder),
+	}
+}
+
+func (migration *Migration) Write(phase Phase, statement string) {
+	var builder *strings.Builder
+	builder = migration.phases[phase]
+	if builder == nil {
+		builder = &strings.Builder{}
+		migration.phases[phase] = builder
+	}
+	builder.WriteString(statement)
+}
+
+func (migration *Migration) Script(phase Phase) string {
+	var builder *strings.Builder
+	builder = migration.phases[phase]
+	if builder == nil {
+		return ""
+	}
+	return builder.String()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"alasimi.com/pg-diff-schema/src/utils"
+)
+
+// RoleMap translates role names of the source database into the ones
+// used by the target, so that environment specific names are not
+// reported as differences
+type RoleMap map[string]string
+
+func (roles *RoleMap) String() string {
+	var list []string
+	for source, target := range *roles {
+		list = append(list, fmt.Sprintf("%s=%s", source, target))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func (roles *RoleMap) Set(value string) error {
+	var parts []string = strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid role mapping `%s', expected source=target", value)
+	}
+	if *roles == nil {
+		*roles = make(RoleMap)
+	}
+	(*roles)[parts[0]] = parts[1]
+	return nil
+}
+
+func (roles RoleMap) Map(role string) string {
+	if mapped, found := roles[role]; found {
+		return mapped
+	}
+	return role
+}
+
+func (roles RoleMap) mapAcl(acl aclArray) {
+	for index := range acl {
+		var item *utils.AclItem = &acl[index]
+		// An empty grantee is PUBLIC
+		if item.Grantee != "" {
+			item.Grantee = roles.Map(item.Grantee)
+		}
+		item.Grantor = roles.Map(item.Grantor)
+	}
+}
+
+// mapRoles rewrites every role name of the schema, it is applied to the
+// source before diffing so generated statements use the target names
+func (schema *Schema) mapRoles(roles RoleMap) {
+	if len(roles) == 0 {
+		return
+	}
+	schema.owner = roles.Map(schema.owner)
+	roles.mapAcl(schema.acl)
+	for _, defaults := range schema.defaultPrivileges {
+		defaults.role = roles.Map(defaults.role)
+		roles.mapAcl(defaults.acl)
+		roles.mapAcl(defaults.defaults)
+	}
+	for _, table := range schema.tables {
+		table.owner = roles.Map(table.owner)
+		roles.mapAcl(table.acl)
+		for _, policy := range table.policies {
+			for index, role := range policy.roles {
+				policy.roles[index] = roles.Map(role)
+			}
+		}
+	}
+	for _, mapping := range schema.userMappings {
+		if mapping.user.Valid {
+			mapping.user.String = roles.Map(mapping.user.String)
+		}
+	}
+	for _, sequence := range schema.sequences {
+		sequence.owner = roles.Map(sequence.owner)
+		roles.mapAcl(sequence.acl)
+	}
+	for _, function := range schema.functions {
+		function.owner = roles.Map(function.owner)
+		roles.mapAcl(function.acl)
+	}
+	for _, item := range schema.types {
+		item.owner = roles.Map(item.owner)
+		roles.mapAcl(item.acl)
+	}
+}
+
+func ownerStatement(object string, owner string) string {
+	return fmt.Sprintf("ALTER %s OWNER TO \"%s\";\n", object, owner)
+}
+
+// examineOwners emits OWNER TO for objects whose owner differs, new
+// objects and the ones dropped and created again always get one since they
+// belong to whoever runs the script
+func (schema *Schema) examineOwners(target *Schema) (string, error) {
+	var builder strings.Builder
+	if schema.owner != target.owner {
+		builder.WriteString(ownerStatement(fmt.Sprintf("SCHEMA \"%s\"", schema.name), schema.owner))
+	}
+	for _, table := range schema.tables {
+		var found *Table = target.FindTableByName(table.name)
+		if found == nil || found.recreated || found.owner != table.owner {
+			builder.WriteString(
+				ownerStatement(fmt.Sprintf("%s \"%s\"", table.keyword(), table.name), table.owner),
+			)
+		}
+	}
+	for _, sequence := range schema.sequences {
+		var found *Sequence = target.FindSequence(sequence)
+		// Sequences owned by a column follow the owner of the table
+		if sequence.ownerTable.Valid {
+			continue
+		}
+		if found == nil || found.owner != sequence.owner {
+			builder.WriteString(ownerStatement(fmt.Sprintf("SEQUENCE \"%s\"", sequence.name), sequence.owner))
+		}
+	}
+	for _, function := range schema.functions {
+		var found *Function = target.FindFunctionBySignature(function.Signature())
+		if found == nil || found.recreated || found.owner != function.owner {
+			builder.WriteString(
+				ownerStatement(fmt.Sprintf("%s %s", function.keyword(), function.Signature()), function.owner),
+			)
+		}
+	}
+	for _, item := range schema.types {
+		var found *Type = target.FindTypeByName(item.name)
+		if found == nil || found.recreated || found.owner != item.owner {
+			builder.WriteString(ownerStatement(fmt.Sprintf("%s \"%s\"", item.keyword(), item.name), item.owner))
+		}
+	}
+	return builder.String(), nil
+}
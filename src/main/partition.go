@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (table *Table) CreatePartitionStatement() string {
+	var builder strings.Builder
+	var unlogged string
+	if table.persistence == "u" {
+		unlogged = "UNLOGGED "
+	}
+	builder.WriteString(
+		fmt.Sprintf(
+			"CREATE %s%s \"%s\" PARTITION OF \"%s\" %s",
+			unlogged,
+			table.keyword(),
+			table.name,
+			table.partitionOf.String,
+			table.partitionBound.String,
+		),
+	)
+	if table.partitionKey.Valid {
+		builder.WriteString(fmt.Sprintf(" PARTITION BY %s", table.partitionKey.String))
+	}
+	if table.kind == ForeignTable {
+		builder.WriteString(table.serverClause())
+	} else {
+		builder.WriteString(table.storageClauses())
+	}
+	builder.WriteString(";\n")
+	// Triggers cloned from the parent are not collected, these are the
+	// ones of the partition itself
+	for _, trigger := range table.triggers {
+		builder.WriteString(trigger.CreateStatement())
+	}
+	return builder.String()
+}
+
+func (table *Table) AttachPartitionStatement(parent string, bound string) string {
+	// The partition is scanned to check the bound unless a matching
+	// CHECK constraint already exists
+	return table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" ATTACH PARTITION \"%s\" %s;\n", parent, table.name, bound),
+		AccessExclusive,
+		FullScan,
+	)
+}
+
+func (table *Table) DetachPartitionStatement() string {
+	return table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" DETACH PARTITION \"%s\";\n", table.partitionOf.String, table.name),
+		AccessExclusive,
+		MetadataOnly,
+	)
+}
+
+// partitionDiff attaches or detaches the target table so that it belongs
+// to the same parent, with the same bound, as this table
+func (table *Table) partitionDiff(target *Table) string {
+	var builder strings.Builder
+	if table.partitionKey != target.partitionKey {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: partition key of \"%s\" changed from `%s' to `%s', it has to be recreated manually\n",
+				table.name,
+				target.partitionKey.String,
+				table.partitionKey.String,
+			),
+		)
+	}
+	if table.partitionOf == target.partitionOf && table.partitionBound == target.partitionBound {
+		return builder.String()
+	}
+	if target.partitionOf.Valid {
+		builder.WriteString(target.DetachPartitionStatement())
+	}
+	if table.partitionOf.Valid {
+		builder.WriteString(target.AttachPartitionStatement(table.partitionOf.String, table.partitionBound.String))
+	}
+	return builder.String()
+}
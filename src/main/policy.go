@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const GetPolicies string = `
+SELECT
+  policyname,
+  permissive,
+  roles,
+  cmd,
+  qual,
+  with_check,
+  (
+    SELECT obj_description(p.oid, 'pg_policy')
+    FROM pg_catalog.pg_policy p
+    WHERE p.polrelid = quote_ident($2)::regclass AND
+      p.polname = policyname
+  )
+FROM pg_catalog.pg_policies
+WHERE schemaname = $1 AND
+  tablename = $2
+ORDER BY policyname
+`
+
+type Policy struct {
+	name       string
+	permissive string
+	roles      stringArray
+	command    string
+	using      sql.NullString
+	check      sql.NullString
+	table      *Table
+	comment    sql.NullString
+}
+
+func getPolicies(db *sql.DB, table *Table) ([]*Policy, error) {
+	var rows *sql.Rows
+	var policies []*Policy
+	var err error
+	if rows, err = db.Query(GetPolicies, table.schema, table.name); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var policy Policy
+		if err = rows.Scan(
+			&policy.name,
+			&policy.permissive,
+			&policy.roles,
+			&policy.command,
+			&policy.using,
+			&policy.check,
+			&policy.comment,
+		); err != nil {
+			return nil, err
+		}
+		policy.table = table
+		policies = append(policies, &policy)
+	}
+	return policies, nil
+}
+
+func quoteRoles(roles []string) string {
+	var list []string
+	for _, role := range roles {
+		if role == "public" {
+			list = append(list, "PUBLIC")
+		} else {
+			list = append(list, fmt.Sprintf("\"%s\"", role))
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+// expressions are the USING and WITH CHECK clauses, as accepted by both
+// CREATE POLICY and ALTER POLICY
+func (policy *Policy) expressions() string {
+	var builder strings.Builder
+	if policy.using.Valid {
+		builder.WriteString(fmt.Sprintf(" USING (%s)", policy.using.String))
+	}
+	if policy.check.Valid {
+		builder.WriteString(fmt.Sprintf(" WITH CHECK (%s)", policy.check.String))
+	}
+	return builder.String()
+}
+
+func (policy *Policy) CreateStatement() string {
+	return fmt.Sprintf(
+		"CREATE POLICY \"%s\" ON \"%s\" AS %s FOR %s TO %s%s;\n",
+		policy.name,
+		policy.table.name,
+		policy.permissive,
+		policy.command,
+		quoteRoles(policy.roles),
+		policy.expressions(),
+	)
+}
+
+func (policy *Policy) DropStatement() string {
+	return fmt.Sprintf("DROP POLICY IF EXISTS \"%s\" ON \"%s\";\n", policy.name, policy.table.name)
+}
+
+// Diff alters the target policy when possible, the kind and command of a
+// policy cannot be altered and neither can an expression be removed
+func (policy *Policy) Diff(target *Policy) string {
+	var builder strings.Builder
+	if policy.permissive != target.permissive ||
+		policy.command != target.command ||
+		(target.using.Valid && !policy.using.Valid) ||
+		(target.check.Valid && !policy.check.Valid) {
+		builder.WriteString(target.DropStatement())
+		builder.WriteString(policy.CreateStatement())
+		return builder.String()
+	}
+	if strings.Join(policy.roles, ",") == strings.Join(target.roles, ",") &&
+		policy.using == target.using &&
+		policy.check == target.check {
+		return ""
+	}
+	return fmt.Sprintf(
+		"ALTER POLICY \"%s\" ON \"%s\" TO %s%s;\n",
+		policy.name,
+		policy.table.name,
+		quoteRoles(policy.roles),
+		policy.expressions(),
+	)
+}
+
+func (table *Table) FindPolicy(name string) *Policy {
+	for _, policy := range table.policies {
+		if policy.name == name {
+			return policy
+		}
+	}
+	return nil
+}
+
+func (table *Table) rowSecurityStatements() string {
+	var builder strings.Builder
+	if table.rowSecurity {
+		builder.WriteString(fmt.Sprintf("ALTER TABLE \"%s\" ENABLE ROW LEVEL SECURITY;\n", table.name))
+	} else {
+		builder.WriteString(fmt.Sprintf("ALTER TABLE \"%s\" DISABLE ROW LEVEL SECURITY;\n", table.name))
+	}
+	if table.forceRowSecurity {
+		builder.WriteString(fmt.Sprintf("ALTER TABLE \"%s\" FORCE ROW LEVEL SECURITY;\n", table.name))
+	} else {
+		builder.WriteString(fmt.Sprintf("ALTER TABLE \"%s\" NO FORCE ROW LEVEL SECURITY;\n", table.name))
+	}
+	return builder.String()
+}
+
+// policyDiff turns the row level security settings and policies of the
+// target table into the ones of this table
+func (table *Table) policyDiff(target *Table) string {
+	var builder strings.Builder
+	if table.rowSecurity != target.rowSecurity || table.forceRowSecurity != target.forceRowSecurity {
+		builder.WriteString(target.annotate(table.rowSecurityStatements(), AccessExclusive, MetadataOnly))
+	}
+	for _, policy := range target.policies {
+		if table.FindPolicy(policy.name) == nil {
+			builder.WriteString(target.annotate(policy.DropStatement(), AccessExclusive, MetadataOnly))
+		}
+	}
+	for _, policy := range table.policies {
+		var found *Policy
+		var statements string
+		found = target.FindPolicy(policy.name)
+		if found == nil {
+			statements = policy.CreateStatement()
+		} else {
+			statements = policy.Diff(found)
+		}
+		if statements != "" {
+			builder.WriteString(target.annotate(statements, AccessExclusive, MetadataOnly))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestPolicyDiff(t *testing.T) {
+	var table *Table = &Table{name: "t"}
+	var owned sql.NullString = sql.NullString{String: "(owner = CURRENT_USER)", Valid: true}
+	var open sql.NullString = sql.NullString{String: "true", Valid: true}
+	var base Policy = Policy{
+		name:       "p",
+		permissive: "PERMISSIVE",
+		roles:      stringArray{"public"},
+		command:    "ALL",
+		using:      owned,
+		table:      table,
+	}
+	var tests = []struct {
+		name       string
+		change     func(policy *Policy)
+		statements string
+	}{
+		{
+			name:   "same",
+			change: func(policy *Policy) {},
+		},
+		{
+			name:       "roles",
+			change:     func(policy *Policy) { policy.roles = stringArray{"app", "public"} },
+			statements: "ALTER POLICY \"p\" ON \"t\" TO \"app\", PUBLIC USING ((owner = CURRENT_USER));\n",
+		},
+		{
+			name:       "using",
+			change:     func(policy *Policy) { policy.using = open },
+			statements: "ALTER POLICY \"p\" ON \"t\" TO PUBLIC USING (true);\n",
+		},
+		{
+			name:   "check added",
+			change: func(policy *Policy) { policy.check = owned },
+			statements: "ALTER POLICY \"p\" ON \"t\" TO PUBLIC " +
+				"USING ((owner = CURRENT_USER)) WITH CHECK ((owner = CURRENT_USER));\n",
+		},
+		{
+			name:   "using removed",
+			change: func(policy *Policy) { policy.using = sql.NullString{}; policy.check = owned },
+			statements: "DROP POLICY IF EXISTS \"p\" ON \"t\";\n" +
+				"CREATE POLICY \"p\" ON \"t\" AS PERMISSIVE FOR ALL TO PUBLIC WITH CHECK ((owner = CURRENT_USER));\n",
+		},
+		{
+			name:   "command",
+			change: func(policy *Policy) { policy.command = "SELECT" },
+			statements: "DROP POLICY IF EXISTS \"p\" ON \"t\";\n" +
+				"CREATE POLICY \"p\" ON \"t\" AS PERMISSIVE FOR SELECT TO PUBLIC USING ((owner = CURRENT_USER));\n",
+		},
+		{
+			name:   "restrictive",
+			change: func(policy *Policy) { policy.permissive = "RESTRICTIVE" },
+			statements: "DROP POLICY IF EXISTS \"p\" ON \"t\";\n" +
+				"CREATE POLICY \"p\" ON \"t\" AS RESTRICTIVE FOR ALL TO PUBLIC USING ((owner = CURRENT_USER));\n",
+		},
+	}
+	for _, test := range tests {
+		var current Policy = base
+		var wanted Policy = base
+		var statements string
+		test.change(&wanted)
+		statements = wanted.Diff(&current)
+		if statements != test.statements {
+			t.Logf("%s: expected %q, got %q", test.name, test.statements, statements)
+			t.Fail()
+		}
+	}
+}
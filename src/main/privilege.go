@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"alasimi.com/pg-diff-schema/src/utils"
+)
+
+const GetSchemaPrivileges string = `
+SELECT
+  COALESCE(nspacl, acldefault('n', nspowner)),
+  pg_get_userbyid(nspowner),
+  obj_description(oid, 'pg_namespace')
+FROM pg_catalog.pg_namespace
+WHERE nspname = $1
+`
+
+// GetDefaultPrivileges returns the built in defaults along with the
+// configured ones, they are needed when only one side configured them,
+// entries for a single schema have none, they add to the global ones
+const GetDefaultPrivileges string = `
+SELECT
+  pg_get_userbyid(d.defaclrole),
+  n.nspname,
+  d.defaclobjtype::text,
+  d.defaclacl,
+  CASE WHEN d.defaclnamespace = 0 THEN
+    acldefault(
+      CASE d.defaclobjtype WHEN 'S' THEN 's' ELSE d.defaclobjtype END,
+      d.defaclrole
+    )
+  END
+FROM pg_catalog.pg_default_acl d
+LEFT JOIN pg_catalog.pg_namespace n ON n.oid = d.defaclnamespace
+WHERE n.nspname IS NULL OR n.nspname = $1
+ORDER BY 1, 2, 3
+`
+
+var privilegeNames map[rune]string = map[rune]string{
+	'r': "SELECT",
+	'w': "UPDATE",
+	'a': "INSERT",
+	'd': "DELETE",
+	'D': "TRUNCATE",
+	'x': "REFERENCES",
+	't': "TRIGGER",
+	'X': "EXECUTE",
+	'U': "USAGE",
+	'C': "CREATE",
+	'c': "CONNECT",
+	'T': "TEMPORARY",
+	'm': "MAINTAIN",
+}
+
+var defaultPrivilegeObjects map[string]string = map[string]string{
+	"r": "TABLES",
+	"S": "SEQUENCES",
+	"f": "FUNCTIONS",
+	"T": "TYPES",
+	"n": "SCHEMAS",
+}
+
+type aclArray []utils.AclItem
+
+func (acl *aclArray) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case []byte:
+		return utils.ParseAcl(value, (*[]utils.AclItem)(acl))
+	case string:
+		return utils.ParseAcl([]byte(value), (*[]utils.AclItem)(acl))
+	case nil:
+		break
+	default:
+		return fmt.Errorf("invalid input type for acl array %t", src)
+	}
+	return nil
+}
+
+// aclDefault mirrors acldefault() of the target server, it is the list a
+// new object of the given kind starts with
+func aclDefault(kind string, owner string, version int) aclArray {
+	var privileges string
+	var public string
+	var acl aclArray
+	switch kind {
+	case "r":
+		privileges = "arwdDxt"
+		if version >= 170000 {
+			privileges += "m"
+		}
+	case "s":
+		privileges = "rwU"
+	case "f":
+		privileges, public = "X", "X"
+	case "T":
+		privileges, public = "U", "U"
+	case "n":
+		privileges = "UC"
+	}
+	acl = aclArray{{Grantee: owner, Privileges: privileges, Grantor: owner}}
+	if public != "" {
+		acl = append(acl, utils.AclItem{Privileges: public, Grantor: owner})
+	}
+	return acl
+}
+
+type DefaultPrivileges struct {
+	role     string
+	schema   sql.NullString
+	kind     string
+	acl      aclArray
+	defaults aclArray
+}
+
+func (defaults *DefaultPrivileges) key() string {
+	return fmt.Sprintf("%s/%s/%s", defaults.role, defaults.schema.String, defaults.kind)
+}
+
+func quoteGrantee(grantee string) string {
+	if grantee == "" {
+		return "PUBLIC"
+	}
+	return fmt.Sprintf("\"%s\"", grantee)
+}
+
+// privilegesOf merges every entry of the grantee, regardless of the
+// grantor, returning the privileges and those that are grantable
+func privilegesOf(acl aclArray, grantee string) (string, string) {
+	var privileges string
+	var grantable string
+	for _, item := range acl {
+		if item.Grantee != grantee {
+			continue
+		}
+		privileges += item.Privileges
+		grantable += item.Grantable
+	}
+	return privileges, grantable
+}
+
+// missingPrivileges lists the names of the privileges in first that are
+// not in second
+func missingPrivileges(first string, second string) []string {
+	var list []string
+	for _, chr := range first {
+		var name string
+		var found bool
+		if strings.ContainsRune(second, chr) {
+			continue
+		}
+		if name, found = privilegeNames[chr]; !found {
+			continue
+		}
+		if indexOf(list, name) == -1 {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+func grantees(acls ...aclArray) []string {
+	var list []string
+	for _, acl := range acls {
+		for _, item := range acl {
+			if indexOf(list, item.Grantee) == -1 {
+				list = append(list, item.Grantee)
+			}
+		}
+	}
+	return list
+}
+
+// grantStatements generates the GRANT and REVOKE commands, the prefix
+// is either GRANT/REVOKE for regular objects or ALTER DEFAULT PRIVILEGES
+// followed by it, and object is what goes after ON
+func grantStatements(prefix string, object string, source aclArray, target aclArray) string {
+	var builder strings.Builder
+	for _, grantee := range grantees(source, target) {
+		var wanted, wantedGrantable string
+		var current, currentGrantable string
+		var list []string
+		wanted, wantedGrantable = privilegesOf(source, grantee)
+		current, currentGrantable = privilegesOf(target, grantee)
+		if list = missingPrivileges(current, wanted); len(list) > 0 {
+			builder.WriteString(
+				fmt.Sprintf("%sREVOKE %s ON %s FROM %s;\n", prefix, strings.Join(list, ", "), object, quoteGrantee(grantee)),
+			)
+		}
+		if list = missingPrivileges(currentGrantable, wantedGrantable); len(list) > 0 {
+			builder.WriteString(
+				fmt.Sprintf(
+					"%sREVOKE GRANT OPTION FOR %s ON %s FROM %s;\n",
+					prefix,
+					strings.Join(list, ", "),
+					object,
+					quoteGrantee(grantee),
+				),
+			)
+		}
+		if list = missingPrivileges(wanted, current); len(list) > 0 {
+			builder.WriteString(
+				fmt.Sprintf("%sGRANT %s ON %s TO %s;\n", prefix, strings.Join(list, ", "), object, quoteGrantee(grantee)),
+			)
+		}
+		if list = missingPrivileges(wantedGrantable, currentGrantable); len(list) > 0 {
+			builder.WriteString(
+				fmt.Sprintf(
+					"%sGRANT %s ON %s TO %s WITH GRANT OPTION;\n",
+					prefix,
+					strings.Join(list, ", "),
+					object,
+					quoteGrantee(grantee),
+				),
+			)
+		}
+	}
+	return builder.String()
+}
+
+func (schema *Schema) collectPrivileges(db *sql.DB, schemaName string) error {
+	var rows *sql.Rows
+	var err error
+	if err = db.QueryRow(GetSchemaPrivileges, schemaName).Scan(&schema.acl, &schema.owner, &schema.comment); err != nil {
+		return err
+	}
+	if rows, err = db.Query(GetDefaultPrivileges, schemaName); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var defaults DefaultPrivileges
+		if err = rows.Scan(
+			&defaults.role,
+			&defaults.schema,
+			&defaults.kind,
+			&defaults.acl,
+			&defaults.defaults,
+		); err != nil {
+			return err
+		}
+		schema.defaultPrivileges = append(schema.defaultPrivileges, &defaults)
+	}
+	return nil
+}
+
+func (schema *Schema) findDefaultPrivileges(key string) *DefaultPrivileges {
+	for _, defaults := range schema.defaultPrivileges {
+		if defaults.key() == key {
+			return defaults
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) defaultPrivilegesDiff(target *Schema) string {
+	var builder strings.Builder
+	var seen []string
+	var all []*DefaultPrivileges
+	all = append(all, schema.defaultPrivileges...)
+	all = append(all, target.defaultPrivileges...)
+	for _, item := range all {
+		var source *DefaultPrivileges
+		var found *DefaultPrivileges
+		var prefix string
+		var wanted aclArray
+		var current aclArray
+		if indexOf(seen, item.key()) != -1 {
+			continue
+		}
+		seen = append(seen, item.key())
+		source = schema.findDefaultPrivileges(item.key())
+		found = target.findDefaultPrivileges(item.key())
+		// A missing entry means the built in defaults apply, there are
+		// none for the entries of a single schema
+		if wanted, current = item.defaults, item.defaults; source != nil {
+			wanted = source.acl
+		}
+		if found != nil {
+			current = found.acl
+		}
+		prefix = fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE \"%s\" ", item.role)
+		if item.schema.Valid {
+			prefix += fmt.Sprintf("IN SCHEMA \"%s\" ", item.schema.String)
+		}
+		builder.WriteString(strings.ReplaceAll(
+			grantStatements(prefix, "\x00", wanted, current),
+			" ON \x00",
+			fmt.Sprintf(" ON %s", defaultPrivilegeObjects[item.kind]),
+		))
+	}
+	return builder.String()
+}
+
+// examinePrivileges runs once every object exists, objects that did not
+// exist in the target, or that the migration drops and creates again, are
+// compared against the defaults of their owner
+func (schema *Schema) examinePrivileges(target *Schema) (string, error) {
+	var builder strings.Builder
+	builder.WriteString(grantStatements("", fmt.Sprintf("SCHEMA \"%s\"", schema.name), schema.acl, target.acl))
+	for _, table := range schema.tables {
+		var current aclArray = aclDefault("r", table.owner, target.version)
+		if found := target.FindTableByName(table.name); found != nil && !found.recreated {
+			current = found.acl
+		}
+		builder.WriteString(grantStatements("", fmt.Sprintf("TABLE \"%s\"", table.name), table.acl, current))
+	}
+	for _, sequence := range schema.sequences {
+		var current aclArray = aclDefault("s", sequence.owner, target.version)
+		if found := target.FindSequence(sequence); found != nil {
+			current = found.acl
+		}
+		builder.WriteString(grantStatements("", fmt.Sprintf("SEQUENCE \"%s\"", sequence.name), sequence.acl, current))
+	}
+	for _, function := range schema.functions {
+		var current aclArray = aclDefault("f", function.owner, target.version)
+		if found := target.FindFunctionBySignature(function.Signature()); found != nil && !found.recreated {
+			current = found.acl
+		}
+		builder.WriteString(
+			grantStatements("", fmt.Sprintf("%s %s", function.keyword(), function.Signature()), function.acl, current),
+		)
+	}
+	for _, item := range schema.types {
+		var current aclArray = aclDefault("T", item.owner, target.version)
+		if found := target.FindTypeByName(item.name); found != nil && !found.recreated {
+			current = found.acl
+		}
+		builder.WriteString(grantStatements("", fmt.Sprintf("TYPE \"%s\"", item.name), item.acl, current))
+	}
+	builder.WriteString(schema.defaultPrivilegesDiff(target))
+	return builder.String(), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"alasimi.com/pg-diff-schema/src/utils"
+)
+
+func TestAclDefault(t *testing.T) {
+	var tests = []struct {
+		kind    string
+		version int
+		acl     aclArray
+	}{
+		{"r", 160000, aclArray{{Grantee: "o", Privileges: "arwdDxt", Grantor: "o"}}},
+		{"r", 170000, aclArray{{Grantee: "o", Privileges: "arwdDxtm", Grantor: "o"}}},
+		{"s", 170000, aclArray{{Grantee: "o", Privileges: "rwU", Grantor: "o"}}},
+		{"f", 170000, aclArray{{Grantee: "o", Privileges: "X", Grantor: "o"}, {Privileges: "X", Grantor: "o"}}},
+		{"T", 170000, aclArray{{Grantee: "o", Privileges: "U", Grantor: "o"}, {Privileges: "U", Grantor: "o"}}},
+		{"n", 170000, aclArray{{Grantee: "o", Privileges: "UC", Grantor: "o"}}},
+	}
+	for _, test := range tests {
+		var acl aclArray = aclDefault(test.kind, "o", test.version)
+		if fmt.Sprint(acl) != fmt.Sprint(test.acl) {
+			t.Logf("%s on %d: expected %v, got %v", test.kind, test.version, test.acl, acl)
+			t.Fail()
+		}
+	}
+}
+
+func TestGrantStatements(t *testing.T) {
+	var owner utils.AclItem = utils.AclItem{Grantee: "o", Privileges: "arwdDxt", Grantor: "o"}
+	var tests = []struct {
+		name       string
+		source     aclArray
+		target     aclArray
+		statements string
+	}{
+		{
+			name:   "same",
+			source: aclArray{owner, {Grantee: "r", Privileges: "r", Grantor: "o"}},
+			target: aclArray{owner, {Grantee: "r", Privileges: "r", Grantor: "o"}},
+		},
+		{
+			name:       "grant",
+			source:     aclArray{owner, {Grantee: "r", Privileges: "ra", Grantor: "o"}},
+			target:     aclArray{owner},
+			statements: "GRANT SELECT, INSERT ON TABLE \"t\" TO \"r\";\n",
+		},
+		{
+			name:       "revoke",
+			source:     aclArray{owner},
+			target:     aclArray{owner, {Grantee: "r", Privileges: "r", Grantor: "o"}},
+			statements: "REVOKE SELECT ON TABLE \"t\" FROM \"r\";\n",
+		},
+		{
+			name:       "public",
+			source:     aclArray{owner, {Privileges: "r", Grantor: "o"}},
+			target:     aclArray{owner},
+			statements: "GRANT SELECT ON TABLE \"t\" TO PUBLIC;\n",
+		},
+		{
+			name:       "grant option",
+			source:     aclArray{owner, {Grantee: "r", Privileges: "r", Grantable: "r", Grantor: "o"}},
+			target:     aclArray{owner, {Grantee: "r", Privileges: "r", Grantor: "o"}},
+			statements: "GRANT SELECT ON TABLE \"t\" TO \"r\" WITH GRANT OPTION;\n",
+		},
+		{
+			name:       "revoke grant option",
+			source:     aclArray{owner, {Grantee: "r", Privileges: "r", Grantor: "o"}},
+			target:     aclArray{owner, {Grantee: "r", Privileges: "r", Grantable: "r", Grantor: "o"}},
+			statements: "REVOKE GRANT OPTION FOR SELECT ON TABLE \"t\" FROM \"r\";\n",
+		},
+		{
+			name:   "other grantor",
+			source: aclArray{owner, {Grantee: "r", Privileges: "r", Grantor: "o"}},
+			target: aclArray{owner, {Grantee: "r", Privileges: "r", Grantor: "x"}},
+		},
+		{
+			name:       "new table",
+			source:     aclArray{{Grantee: "o", Privileges: "arwd", Grantor: "o"}},
+			target:     aclDefault("r", "o", 160000),
+			statements: "REVOKE TRUNCATE, REFERENCES, TRIGGER ON TABLE \"t\" FROM \"o\";\n",
+		},
+	}
+	for _, test := range tests {
+		var statements string = grantStatements("", "TABLE \"t\"", test.source, test.target)
+		if statements != test.statements {
+			t.Logf("%s: expected %q, got %q", test.name, test.statements, statements)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const GetPublications string = `
+SELECT
+  p.oid,
+  p.pubname,
+  p.puballtables,
+  p.pubinsert,
+  p.pubupdate,
+  p.pubdelete,
+  p.pubtruncate,
+  p.pubviaroot,
+  ARRAY(
+    SELECT n.nspname::text
+    FROM pg_catalog.pg_publication_namespace pn
+    JOIN pg_catalog.pg_namespace n ON n.oid = pn.pnnspid
+    WHERE pn.pnpubid = p.oid
+    ORDER BY n.nspname
+  )
+FROM pg_catalog.pg_publication p
+ORDER BY p.pubname
+`
+
+// GetPublicationTables lists the tables explicitly added to the
+// publication, along with their column list and row filter
+const GetPublicationTables string = `
+SELECT
+  n.nspname,
+  c.relname,
+  ARRAY(
+    SELECT a.attname
+    FROM unnest(r.prattrs::int2[]) WITH ORDINALITY AS k(attnum, position)
+    JOIN pg_catalog.pg_attribute a ON a.attrelid = r.prrelid AND a.attnum = k.attnum
+    ORDER BY k.position
+  ),
+  pg_get_expr(r.prqual, r.prrelid)
+FROM pg_catalog.pg_publication_rel r
+JOIN pg_catalog.pg_class c ON c.oid = r.prrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE r.prpubid = $1
+ORDER BY n.nspname, c.relname
+`
+
+// GetLegacyPublications is used before PostgreSQL 15, which added
+// schemas to publications, older columns are read through to_jsonb() as
+// they do not exist in every version
+const GetLegacyPublications string = `
+SELECT
+  p.oid,
+  p.pubname,
+  p.puballtables,
+  p.pubinsert,
+  p.pubupdate,
+  p.pubdelete,
+  COALESCE(to_jsonb(p)->>'pubtruncate', 'false')::boolean,
+  COALESCE(to_jsonb(p)->>'pubviaroot', 'false')::boolean,
+  ARRAY[]::text[]
+FROM pg_catalog.pg_publication p
+ORDER BY p.pubname
+`
+
+// GetLegacyPublicationTables is used before PostgreSQL 15, publications
+// had neither column lists nor row filters
+const GetLegacyPublicationTables string = `
+SELECT
+  n.nspname,
+  c.relname,
+  ARRAY[]::text[],
+  NULL::text
+FROM pg_catalog.pg_publication_rel r
+JOIN pg_catalog.pg_class c ON c.oid = r.prrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE r.prpubid = $1
+ORDER BY n.nspname, c.relname
+`
+
+// GetSubscriptions leaves the connection string out, it holds credentials
+// and is not readable by regular users anyway
+const GetSubscriptions string = `
+SELECT
+  s.subname,
+  s.subenabled,
+  s.subpublications
+FROM pg_catalog.pg_subscription s
+JOIN pg_catalog.pg_database d ON d.oid = s.subdbid
+WHERE d.datname = current_database()
+ORDER BY s.subname
+`
+
+type PublicationTable struct {
+	schema  string
+	name    string
+	columns stringArray
+	filter  sql.NullString
+}
+
+type Publication struct {
+	oid       int
+	name      string
+	allTables bool
+	insert    bool
+	update    bool
+	delete    bool
+	truncate  bool
+	viaRoot   bool
+	schemas   stringArray
+	tables    []*PublicationTable
+}
+
+type Subscription struct {
+	name         string
+	enabled      bool
+	publications stringArray
+}
+
+func (table *PublicationTable) QualifiedName() string {
+	return fmt.Sprintf("\"%s\".\"%s\"", table.schema, table.name)
+}
+
+// clause is the table as listed in FOR TABLE or ADD TABLE
+func (table *PublicationTable) clause() string {
+	var builder strings.Builder
+	builder.WriteString(table.QualifiedName())
+	if len(table.columns) > 0 {
+		builder.WriteString(fmt.Sprintf(" (\"%s\")", strings.Join(table.columns, "\", \"")))
+	}
+	if table.filter.Valid {
+		builder.WriteString(fmt.Sprintf(" WHERE (%s)", table.filter.String))
+	}
+	return builder.String()
+}
+
+func (publication *Publication) collectTables(db *sql.DB, query string) error {
+	var rows *sql.Rows
+	var err error
+	if rows, err = db.Query(query, publication.oid); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var table PublicationTable
+		if err = rows.Scan(&table.schema, &table.name, &table.columns, &table.filter); err != nil {
+			return err
+		}
+		publication.tables = append(publication.tables, &table)
+	}
+	return nil
+}
+
+func (publication *Publication) FindTable(name string) *PublicationTable {
+	for _, table := range publication.tables {
+		if table.QualifiedName() == name {
+			return table
+		}
+	}
+	return nil
+}
+
+// options are the parameters of WITH or SET as understood by the version of
+// the server, truncate was added in PostgreSQL 11 and
+// publish_via_partition_root in PostgreSQL 13
+func (publication *Publication) options(version int) string {
+	var operations []string
+	if publication.insert {
+		operations = append(operations, "insert")
+	}
+	if publication.update {
+		operations = append(operations, "update")
+	}
+	if publication.delete {
+		operations = append(operations, "delete")
+	}
+	if publication.truncate && version >= 110000 {
+		operations = append(operations, "truncate")
+	}
+	if version < 130000 {
+		return fmt.Sprintf("publish = '%s'", strings.Join(operations, ", "))
+	}
+	return fmt.Sprintf(
+		"publish = '%s', publish_via_partition_root = %t",
+		strings.Join(operations, ", "),
+		publication.viaRoot,
+	)
+}
+
+// objects lists the tables and schemas as in FOR or SET
+func (publication *Publication) objects() []string {
+	var list []string
+	for _, table := range publication.tables {
+		list = append(list, fmt.Sprintf("TABLE %s", table.clause()))
+	}
+	for _, schema := range publication.schemas {
+		list = append(list, fmt.Sprintf("TABLES IN SCHEMA \"%s\"", schema))
+	}
+	return list
+}
+
+func (publication *Publication) CreateStatement(version int) string {
+	var builder strings.Builder
+	var list []string = publication.objects()
+	builder.WriteString(fmt.Sprintf("CREATE PUBLICATION \"%s\"", publication.name))
+	if publication.allTables {
+		builder.WriteString(" FOR ALL TABLES")
+	} else if len(list) > 0 {
+		builder.WriteString(fmt.Sprintf(" FOR %s", strings.Join(list, ", ")))
+	}
+	builder.WriteString(fmt.Sprintf(" WITH (%s);\n", publication.options(version)))
+	return builder.String()
+}
+
+func (publication *Publication) DropStatement() string {
+	return fmt.Sprintf("DROP PUBLICATION IF EXISTS \"%s\";\n", publication.name)
+}
+
+// Diff alters the target publication on a server of the given version,
+// dropped tells whether a table of the target is dropped by the migration,
+// which already removes it from the publication
+func (publication *Publication) Diff(target *Publication, version int, dropped func(*PublicationTable) bool) string {
+	var builder strings.Builder
+	var prefix string = fmt.Sprintf("ALTER PUBLICATION \"%s\"", publication.name)
+	if publication.allTables != target.allTables {
+		// FOR ALL TABLES cannot be altered
+		builder.WriteString(target.DropStatement())
+		builder.WriteString(publication.CreateStatement(version))
+		return builder.String()
+	}
+	if publication.options(version) != target.options(version) {
+		builder.WriteString(fmt.Sprintf("%s SET (%s);\n", prefix, publication.options(version)))
+	}
+	for _, table := range publication.tables {
+		var found *PublicationTable = target.FindTable(table.QualifiedName())
+		if found != nil && found.clause() != table.clause() {
+			// Changing the column list or the row filter of a table
+			// replaces every table and schema of the publication at once
+			builder.WriteString(fmt.Sprintf("%s SET %s;\n", prefix, strings.Join(publication.objects(), ", ")))
+			return builder.String()
+		}
+	}
+	for _, table := range target.tables {
+		var found *PublicationTable = publication.FindTable(table.QualifiedName())
+		if dropped(table) {
+			continue
+		}
+		if found == nil {
+			builder.WriteString(fmt.Sprintf("%s DROP TABLE %s;\n", prefix, table.QualifiedName()))
+		}
+	}
+	for _, table := range publication.tables {
+		if target.FindTable(table.QualifiedName()) == nil {
+			builder.WriteString(fmt.Sprintf("%s ADD TABLE %s;\n", prefix, table.clause()))
+		}
+	}
+	for _, schema := range target.schemas {
+		if indexOf(publication.schemas, schema) == -1 {
+			builder.WriteString(fmt.Sprintf("%s DROP TABLES IN SCHEMA \"%s\";\n", prefix, schema))
+		}
+	}
+	for _, schema := range publication.schemas {
+		if indexOf(target.schemas, schema) == -1 {
+			builder.WriteString(fmt.Sprintf("%s ADD TABLES IN SCHEMA \"%s\";\n", prefix, schema))
+		}
+	}
+	return builder.String()
+}
+
+// Diff only reports the differences, subscriptions connect to another
+// server and their connection string is not known
+func (subscription *Subscription) Diff(target *Subscription) string {
+	var builder strings.Builder
+	var state string = "enabled"
+	if !subscription.enabled {
+		state = "disabled"
+	}
+	if strings.Join(subscription.publications, ",") != strings.Join(target.publications, ",") {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: subscription \"%s\" subscribes to %s instead of %s\n",
+				subscription.name,
+				strings.Join(target.publications, ", "),
+				strings.Join(subscription.publications, ", "),
+			),
+		)
+	}
+	if subscription.enabled != target.enabled {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: subscription \"%s\" should be %s\n",
+				subscription.name,
+				state,
+			),
+		)
+	}
+	return builder.String()
+}
+
+func (schema *Schema) collectPublications(db *sql.DB) error {
+	var rows *sql.Rows
+	var err error
+	var query string = GetPublications
+	var tablesQuery string = GetPublicationTables
+	if schema.version < 150000 {
+		query = GetLegacyPublications
+		tablesQuery = GetLegacyPublicationTables
+	}
+	if rows, err = db.Query(query); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var publication Publication
+		if err = rows.Scan(
+			&publication.oid,
+			&publication.name,
+			&publication.allTables,
+			&publication.insert,
+			&publication.update,
+			&publication.delete,
+			&publication.truncate,
+			&publication.viaRoot,
+			&publication.schemas,
+		); err != nil {
+			return err
+		}
+		schema.publications = append(schema.publications, &publication)
+	}
+	for _, publication := range schema.publications {
+		if err = publication.collectTables(db, tablesQuery); err != nil {
+			return err
+		}
+	}
+	if rows, err = db.Query(GetSubscriptions); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var subscription Subscription
+		if err = rows.Scan(&subscription.name, &subscription.enabled, &subscription.publications); err != nil {
+			return err
+		}
+		schema.subscriptions = append(schema.subscriptions, &subscription)
+	}
+	return nil
+}
+
+func (schema *Schema) FindPublicationByName(name string) *Publication {
+	for _, publication := range schema.publications {
+		if publication.name == name {
+			return publication
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) FindSubscriptionByName(name string) *Subscription {
+	for _, subscription := range schema.subscriptions {
+		if subscription.name == name {
+			return subscription
+		}
+	}
+	return nil
+}
+
+// examinePublications runs once every table exists, tables dropped by the
+// migration are already gone from the publications of the target
+func (schema *Schema) examinePublications(target *Schema) (string, error) {
+	var builder strings.Builder
+	var dropped func(*PublicationTable) bool = func(table *PublicationTable) bool {
+		return table.schema == schema.name && schema.FindTableByName(table.name) == nil
+	}
+	for _, publication := range schema.publications {
+		var found *Publication = target.FindPublicationByName(publication.name)
+		var wanted Publication = *publication
+		wanted.tables = nil
+		// Tables of other schemas are only compared when both publish
+		// them, the ones published by one side alone are left as they are
+		for _, table := range publication.tables {
+			if table.schema != schema.name && (found == nil || found.FindTable(table.QualifiedName()) == nil) {
+				builder.WriteString(
+					fmt.Sprintf(
+						"-- \033[31mWARNING\033[0m: table %s of publication \"%s\" may not exist in the target, it has to be added manually\n",
+						table.QualifiedName(),
+						publication.name,
+					),
+				)
+				continue
+			}
+			wanted.tables = append(wanted.tables, table)
+		}
+		if found == nil {
+			builder.WriteString(wanted.CreateStatement(target.version))
+			continue
+		}
+		for _, table := range found.tables {
+			if table.schema != schema.name && publication.FindTable(table.QualifiedName()) == nil {
+				wanted.tables = append(wanted.tables, table)
+			}
+		}
+		builder.WriteString(wanted.Diff(found, target.version, dropped))
+	}
+	for _, publication := range target.publications {
+		if schema.FindPublicationByName(publication.name) == nil {
+			builder.WriteString(publication.DropStatement())
+		}
+	}
+	for _, subscription := range schema.subscriptions {
+		var found *Subscription
+		if found = target.FindSubscriptionByName(subscription.name); found == nil {
+			builder.WriteString(
+				fmt.Sprintf(
+					"-- \033[31mWARNING\033[0m: subscription \"%s\" to %s is missing, it has to be created manually\n",
+					subscription.name,
+					strings.Join(subscription.publications, ", "),
+				),
+			)
+		} else {
+			builder.WriteString(subscription.Diff(found))
+		}
+	}
+	for _, subscription := range target.subscriptions {
+		if schema.FindSubscriptionByName(subscription.name) == nil {
+			builder.WriteString(
+				fmt.Sprintf(
+					"-- \033[31mWARNING\033[0m: subscription \"%s\" only exists in the target\n",
+					subscription.name,
+				),
+			)
+		}
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestPublicationDiff(t *testing.T) {
+	var users *PublicationTable = &PublicationTable{schema: "public", name: "users"}
+	var orders *PublicationTable = &PublicationTable{schema: "public", name: "orders"}
+	var filtered *PublicationTable = &PublicationTable{
+		schema: "public",
+		name:   "users",
+		filter: sql.NullString{String: "active", Valid: true},
+	}
+	var tests = []struct {
+		name       string
+		wanted     Publication
+		current    Publication
+		version    int
+		dropped    bool
+		statements string
+	}{
+		{
+			name:    "same",
+			wanted:  Publication{name: "p", insert: true, tables: []*PublicationTable{users}},
+			current: Publication{name: "p", insert: true, tables: []*PublicationTable{users}},
+			version: 170000,
+		},
+		{
+			name:       "options",
+			wanted:     Publication{name: "p", insert: true, truncate: true, viaRoot: true},
+			current:    Publication{name: "p", insert: true},
+			version:    170000,
+			statements: "ALTER PUBLICATION \"p\" SET (publish = 'insert, truncate', publish_via_partition_root = true);\n",
+		},
+		{
+			name:       "options before PostgreSQL 13",
+			wanted:     Publication{name: "p", insert: true, update: true, truncate: true, viaRoot: true},
+			current:    Publication{name: "p", insert: true},
+			version:    120000,
+			statements: "ALTER PUBLICATION \"p\" SET (publish = 'insert, update, truncate');\n",
+		},
+		{
+			name:    "truncate before PostgreSQL 11",
+			wanted:  Publication{name: "p", insert: true, truncate: true},
+			current: Publication{name: "p", insert: true},
+			version: 100000,
+		},
+		{
+			name:       "table added",
+			wanted:     Publication{name: "p", tables: []*PublicationTable{users, orders}},
+			current:    Publication{name: "p", tables: []*PublicationTable{users}},
+			version:    170000,
+			statements: "ALTER PUBLICATION \"p\" ADD TABLE \"public\".\"orders\";\n",
+		},
+		{
+			name:       "table removed",
+			wanted:     Publication{name: "p", tables: []*PublicationTable{users}},
+			current:    Publication{name: "p", tables: []*PublicationTable{users, orders}},
+			version:    170000,
+			statements: "ALTER PUBLICATION \"p\" DROP TABLE \"public\".\"orders\";\n",
+		},
+		{
+			name:    "table dropped by the migration",
+			wanted:  Publication{name: "p"},
+			current: Publication{name: "p", tables: []*PublicationTable{users}},
+			version: 170000,
+			dropped: true,
+		},
+		{
+			name:       "row filter",
+			wanted:     Publication{name: "p", tables: []*PublicationTable{filtered, orders}},
+			current:    Publication{name: "p", tables: []*PublicationTable{users, orders}},
+			version:    170000,
+			statements: "ALTER PUBLICATION \"p\" SET TABLE \"public\".\"users\" WHERE (active), TABLE \"public\".\"orders\";\n",
+		},
+		{
+			name:    "schemas",
+			wanted:  Publication{name: "p", schemas: stringArray{"sales"}},
+			current: Publication{name: "p", schemas: stringArray{"hr"}},
+			version: 170000,
+			statements: "ALTER PUBLICATION \"p\" DROP TABLES IN SCHEMA \"hr\";\n" +
+				"ALTER PUBLICATION \"p\" ADD TABLES IN SCHEMA \"sales\";\n",
+		},
+		{
+			name:    "all tables",
+			wanted:  Publication{name: "p", allTables: true, insert: true},
+			current: Publication{name: "p", insert: true, tables: []*PublicationTable{users}},
+			version: 170000,
+			statements: "DROP PUBLICATION IF EXISTS \"p\";\n" +
+				"CREATE PUBLICATION \"p\" FOR ALL TABLES WITH (publish = 'insert', publish_via_partition_root = false);\n",
+		},
+	}
+	for _, test := range tests {
+		var dropped func(*PublicationTable) bool = func(table *PublicationTable) bool {
+			return test.dropped
+		}
+		var statements string = test.wanted.Diff(&test.current, test.version, dropped)
+		if statements != test.statements {
+			t.Logf("%s: expected %q, got %q", test.name, test.statements, statements)
+			t.Fail()
+		}
+	}
+}
@@ -4,25 +4,48 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 
 	"alasimi.com/pg-diff-schema/src/utils"
 )
 
 type Schema struct {
-	tables    []*Table
-	sequences []*Sequence
-	types     []*Type
-	name      string
+	tables     []*Table
+	sequences  []*Sequence
+	types      []*Type
+	functions  []*Function
+	collations []*Collation
+	extensions []*Extension
+	name       string
+	encoding   string
+	collate    string
+	ctype      string
+	db         *sql.DB
+	// Foreign data wrappers, servers and user mappings
+	wrappers     []*ForeignDataWrapper
+	servers      []*ForeignServer
+	userMappings []*UserMapping
+	// Logical replication
+	publications  []*Publication
+	subscriptions []*Subscription
+	// Privileges on the schema itself and the default privileges that
+	// apply to objects created in it
+	acl               aclArray
+	defaultPrivileges []*DefaultPrivileges
+	owner             string
+	comment           sql.NullString
+	// As in server_version_num, e.g. 150004
+	version int
 }
 
-const GetSequences string = "SELECT sequencename FROM pg_catalog.pg_sequences WHERE schemaname = $1"
+const GetServerVersion string = `SELECT current_setting('server_version_num')::int`
 
 func (schema *Schema) collectConstraints(db *sql.DB) error {
 	var err error
 	// Second pass now also get relations
 	for _, table := range schema.tables {
-		if table.kind == BaseTable {
+		if !table.isView() {
 			table.constraints, err = getConstraints(db, *table, *schema)
 			if err != nil {
 				return err
@@ -32,9 +55,163 @@ func (schema *Schema) collectConstraints(db *sql.DB) error {
 	return nil
 }
 
+func (schema *Schema) collectTriggers(db *sql.DB) error {
+	var err error
+	for _, table := range schema.tables {
+		if table.triggers, err = getTriggers(db, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) collectPolicies(db *sql.DB) error {
+	var err error
+	for _, table := range schema.tables {
+		if table.kind != BaseTable {
+			continue
+		}
+		if table.policies, err = getPolicies(db, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) collectIndexes(db *sql.DB) error {
+	var err error
+	for _, table := range schema.tables {
+		if table.kind != MaterializedView {
+			continue
+		}
+		if table.indexes, err = getIndexes(db, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) collectDependents(db *sql.DB) error {
+	var err error
+	for _, table := range schema.tables {
+		if err = table.collectDependents(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependentsOf lists every view depending, directly or not, on the table,
+// a view always comes after the views it depends on
+func (schema *Schema) dependentsOf(table *Table) []string {
+	var list []string
+	var queue []string
+	queue = append(queue, table.dependents...)
+	for len(queue) > 0 {
+		var name string
+		var found *Table
+		var index int
+		name = queue[0]
+		queue = queue[1:]
+		if index = indexOf(list, name); index != -1 {
+			list = append(list[:index], list[index+1:]...)
+		}
+		list = append(list, name)
+		if found = schema.FindTableByName(name); found != nil {
+			queue = append(queue, found.dependents...)
+		}
+	}
+	return list
+}
+
+// recreateStatements drops the relation from the target together with the
+// views depending on it, then creates all of them again from this schema,
+// it also returns the names of the views created again
+func (schema *Schema) recreateStatements(target *Schema, table *Table) (string, []string) {
+	var builder strings.Builder
+	var found *Table
+	var names []string
+	found = target.FindTableByName(table.name)
+	builder.WriteString(found.DropStatement())
+	builder.WriteString(table.CreateStatement())
+	found.recreated = true
+	for _, name := range target.dependentsOf(found) {
+		var dependent *Table
+		if dependent = target.FindTableByName(name); dependent != nil {
+			dependent.recreated = true
+		}
+		dependent = schema.FindTableByName(name)
+		if dependent != nil {
+			builder.WriteString(dependent.CreateStatement())
+			names = append(names, name)
+		}
+	}
+	return builder.String(), names
+}
+
+// dropViewsUsing drops the views of this schema using the table, directly
+// or not, they are created again by examineIntersectingViews
+func (schema *Schema) dropViewsUsing(table *Table) string {
+	var builder strings.Builder
+	for _, name := range schema.dependentsOf(table) {
+		var view *Table = schema.FindTableByName(name)
+		if view != nil && !view.dropped {
+			builder.WriteString(view.DropStatement())
+			view.dropped = true
+			view.recreated = true
+		}
+	}
+	return builder.String()
+}
+
+// kindChangeStatements replaces the relation of the target by one of the
+// kind it has in this schema, tables are never dropped since their rows
+// would be lost
+func (schema *Schema) kindChangeStatements(target *Schema, table *Table) string {
+	var builder strings.Builder
+	var found *Table = target.FindTableByName(table.name)
+	if found.kind == BaseTable {
+		return fmt.Sprintf(
+			"-- \033[31mWARNING\033[0m: \"%s\" is a %s in the target instead of a %s, it has to be migrated manually\n",
+			table.name,
+			strings.ToLower(found.keyword()),
+			strings.ToLower(table.keyword()),
+		)
+	}
+	builder.WriteString(target.dropViewsUsing(found))
+	builder.WriteString(found.DropStatement())
+	builder.WriteString(table.CreateStatement())
+	found.recreated = true
+	return builder.String()
+}
+
+// sortViews orders the views so that every view comes after the views of
+// the list it uses
+func (schema *Schema) sortViews(views []*Table) []*Table {
+	var sorted []*Table
+	var seen map[string]bool = make(map[string]bool)
+	var visit func(view *Table)
+	visit = func(view *Table) {
+		if seen[view.name] {
+			return
+		}
+		seen[view.name] = true
+		for _, other := range views {
+			if indexOf(other.dependents, view.name) != -1 {
+				visit(other)
+			}
+		}
+		sorted = append(sorted, view)
+	}
+	for _, view := range views {
+		visit(view)
+	}
+	return sorted
+}
+
 func isSequenceInArray(array []*Sequence, value *Sequence) bool {
 	for _, item := range array {
-		if value.name == item.name {
+		if value.Equal(item) {
 			return true
 		}
 	}
@@ -80,7 +257,7 @@ func (schema *Schema) tableSetDifference(other *Schema) ([]*Table, error) {
 	for _, table := range schema.tables {
 		var found *Table
 		found = other.FindTableByName(table.name)
-		if found == nil || found.kind == View {
+		if found == nil {
 			tables = append(tables, table)
 		}
 	}
@@ -98,32 +275,94 @@ func (schema *Schema) collectTypes(db *sql.DB, schemaName string) error {
 	for rows.Next() {
 		var item Type
 		if err = rows.Scan(
+			&item.oid,
 			&item.name,
 			&array,
-			&item.isEnum,
+			&item.kind,
+			&item.baseType,
+			&item.notNull,
+			&item.defaultValue,
+			&item.subtype,
+			&item.subtypeOpClass,
+			&item.canonical,
+			&item.subtypeDiff,
+			&item.acl,
+			&item.owner,
+			&item.comment,
 		); err != nil {
 			return err
 		}
-		if !item.isEnum {
-			continue
-			// return errors.New("item was expected to be an enum, but it is not")
-		}
 		if err = utils.ParseArray(array, &item.values); err != nil {
 			return err
 		}
 		schema.types = append(schema.types, &item)
 	}
+	// Second pass, composite attributes and domain constraints
+	for _, item := range schema.types {
+		if err = item.collectDetails(db); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (schema *Schema) findSequence(name string) *Sequence {
+func (schema *Schema) collectFunctions(db *sql.DB, schemaName string) error {
+	var rows *sql.Rows
+	var err error
+	if rows, err = db.Query(GetFunctions, schemaName); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var function Function
+		if err = function.collect(rows); err != nil {
+			return err
+		}
+		schema.functions = append(schema.functions, &function)
+	}
+	return nil
+}
+
+func (schema *Schema) FindFunctionBySignature(signature string) *Function {
+	for _, function := range schema.functions {
+		if function.Signature() == signature {
+			return function
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) examineFunctions(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, function := range schema.functions {
+		var found *Function
+		found = target.FindFunctionBySignature(function.Signature())
+		if found == nil {
+			builder.WriteString(function.CreateStatement())
+		} else {
+			builder.WriteString(function.Diff(found))
+		}
+	}
+	return builder.String(), nil
+}
+
+func (schema *Schema) generateNeededDropFunctionStatements(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, function := range target.functions {
+		if schema.FindFunctionBySignature(function.Signature()) == nil {
+			builder.WriteString(function.DropStatement())
+		}
+	}
+	return builder.String(), nil
+}
+
+func (schema *Schema) findSequence(schemaName string, name string) *Sequence {
 	for _, table := range schema.tables {
 		for _, column := range table.columns {
 			var defaultValue interface{}
 			defaultValue = column.defaultValue
 			switch sequence := defaultValue.(type) {
 			case *Sequence:
-				if sequence.name == name {
+				if sequence.name == name && sequence.schema == schemaName {
 					return sequence
 				}
 				break
@@ -133,27 +372,87 @@ func (schema *Schema) findSequence(name string) *Sequence {
 	return nil
 }
 
-func (schema *Schema) collectSequences(db *sql.DB) error {
+func (schema *Schema) collectSequences(db *sql.DB, schemaName string) error {
 	var rows *sql.Rows
 	var err error
-	if rows, err = db.Query(GetSequences, "public"); err != nil {
+	if rows, err = db.Query(GetSequences, schemaName); err != nil {
 		return err
 	}
 	for rows.Next() {
-		var sequence *Sequence
-		var name string
-		err = rows.Scan(&name)
-		if err != nil {
+		var sequence Sequence
+		var found *Sequence
+		if err = sequence.collect(rows); err != nil {
 			return err
 		}
-		sequence = schema.findSequence(name)
-		if sequence != nil {
-			schema.sequences = append(schema.sequences, sequence)
+		// Columns using the sequence as default point to it already
+		found = schema.findSequence(sequence.schema, sequence.name)
+		if found != nil {
+			sequence.column = found.column
+			*found = sequence
+		} else {
+			found = &sequence
 		}
+		schema.sequences = append(schema.sequences, found)
 	}
 	return nil
 }
 
+// FindSequence looks for the sequence with the same schema and name
+func (schema *Schema) FindSequence(search *Sequence) *Sequence {
+	for _, sequence := range schema.sequences {
+		if sequence.Equal(search) {
+			return sequence
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) examineIntersectingSequences(target *Schema, migration *Migration) (string, error) {
+	var builder strings.Builder
+	for _, sequence := range schema.sequences {
+		var found *Sequence
+		found = target.FindSequence(sequence)
+		if found == nil {
+			continue
+		}
+		builder.WriteString(sequence.Diff(found))
+		if migration.options.syncSequences && sequence.lastValue.Valid && sequence.lastValue != found.lastValue {
+			builder.WriteString(sequence.SetValueStatement())
+		}
+	}
+	return builder.String(), nil
+}
+
+// generateSequenceOwnershipStatements must run after the tables have been
+// created, as OWNED BY needs the column to exist
+func (schema *Schema) generateSequenceOwnershipStatements(target *Schema) (string, error) {
+	var builder strings.Builder
+	for _, sequence := range schema.sequences {
+		var found *Sequence
+		found = target.FindSequence(sequence)
+		if found == nil && !sequence.ownerTable.Valid {
+			continue
+		} else if found != nil && sequence.ownerEqual(found) {
+			continue
+		}
+		builder.WriteString(sequence.OwnedByStatement())
+	}
+	return builder.String(), nil
+}
+
+func (schema *Schema) generateNeededDropSequenceStatements(target *Schema) (string, error) {
+	var sequences []*Sequence
+	var builder strings.Builder
+	var err error
+	if sequences, err = target.sequenceSetDifference(schema); err != nil {
+		return "", err
+	}
+	for _, item := range sequences {
+		builder.WriteString(item.DropStatement())
+	}
+	return builder.String(), nil
+}
+
 func removeSemicolon(statement string) string {
 	return strings.Trim(statement, ";")
 }
@@ -174,6 +473,23 @@ func (schema *Schema) collectTables(db *sql.DB, catalog string, schemaName strin
 			&table.schema,
 			&table.catalog,
 			&viewDefinition,
+			&table.estimatedRows,
+			&table.size,
+			&table.populated,
+			&table.options,
+			&table.partitionKey,
+			&table.partitionOf,
+			&table.partitionBound,
+			&table.inherits,
+			&table.rowSecurity,
+			&table.forceRowSecurity,
+			&table.acl,
+			&table.owner,
+			&table.comment,
+			&table.tablespace,
+			&table.persistence,
+			&table.server,
+			&table.foreignOptions,
 		); err != nil {
 			return err
 		}
@@ -195,7 +511,7 @@ func (schema *Schema) collectColumns(db *sql.DB) error {
 	return nil
 }
 
-func (schema *Schema) examineIntersectingTables(target *Schema) (string, error) {
+func (schema *Schema) examineIntersectingTables(target *Schema, migration *Migration) (string, error) {
 	var tables []*Table
 	var err error
 	var builder strings.Builder
@@ -209,7 +525,18 @@ func (schema *Schema) examineIntersectingTables(target *Schema) (string, error)
 		if found == nil {
 			return "", fmt.Errorf("table `%s' not found in target schema", table.name)
 		}
-		if tmp, err = table.Diff(found); err != nil {
+		if table.isView() {
+			// Views are examined once every table is in place
+			continue
+		}
+		if table.kind != found.kind {
+			builder.WriteString(schema.kindChangeStatements(target, table))
+			continue
+		}
+		if table.breaksViews(found, migration) {
+			builder.WriteString(target.dropViewsUsing(found))
+		}
+		if tmp, err = table.Diff(found, migration); err != nil {
 			return "", err
 		}
 		builder.WriteString(tmp)
@@ -217,6 +544,56 @@ func (schema *Schema) examineIntersectingTables(target *Schema) (string, error)
 	return builder.String(), nil
 }
 
+// examineIntersectingViews diffs the views of both schemas and creates the
+// new ones in the same pass, since views of either kind may use the others
+func (schema *Schema) examineIntersectingViews(target *Schema) (string, error) {
+	var views []*Table
+	var created []string
+	var builder strings.Builder
+	for _, table := range schema.tables {
+		if table.isView() {
+			views = append(views, table)
+		}
+	}
+	for _, table := range schema.sortViews(views) {
+		var found *Table
+		var recreate bool
+		var tmp string
+		var names []string
+		found = target.FindTableByName(table.name)
+		if indexOf(created, table.name) != -1 {
+			continue
+		}
+		if found == nil {
+			builder.WriteString(table.CreateStatement())
+			continue
+		}
+		if found.dropped && table.kind == found.kind {
+			builder.WriteString(table.CreateStatement())
+			continue
+		}
+		if table.kind != found.kind {
+			builder.WriteString(schema.kindChangeStatements(target, table))
+			continue
+		}
+		if table.kind == View {
+			recreate, tmp = table.viewDiff(found)
+		} else {
+			recreate, tmp = table.materializedViewDiff(found)
+		}
+		if recreate {
+			tmp, names = schema.recreateStatements(target, table)
+			created = append(created, names...)
+		} else if table.kind == View {
+			// INSTEAD OF triggers, those of views created again come
+			// along with them
+			tmp += table.triggerDiff(found)
+		}
+		builder.WriteString(tmp)
+	}
+	return builder.String(), nil
+}
+
 func (schema *Schema) generateNeededCreateTableStatements(target *Schema) (string, error) {
 	var tables []*Table
 	var builder strings.Builder
@@ -224,8 +601,15 @@ func (schema *Schema) generateNeededCreateTableStatements(target *Schema) (strin
 	if tables, err = schema.tableSetDifference(target); err != nil {
 		return "", err
 	}
+	// Parents before their partitions or children, views are created by
+	// examineIntersectingViews along with the existing ones
+	sort.SliceStable(tables, func(i, j int) bool {
+		return schema.inheritanceLevel(tables[i]) < schema.inheritanceLevel(tables[j])
+	})
 	for _, table := range tables {
-		builder.WriteString(table.CreateStatement())
+		if !table.isView() {
+			builder.WriteString(table.CreateStatement())
+		}
 	}
 	return builder.String(), nil
 }
@@ -243,7 +627,7 @@ func (schema *Schema) generateNeededDropTableStatements(target *Schema) (string,
 	return builder.String(), nil
 }
 
-func (schema *Schema) generateNeededCreateSequenceStatements(target *Schema) (string, error) {
+func (schema *Schema) generateNeededCreateSequenceStatements(target *Schema, migration *Migration) (string, error) {
 	var sequences []*Sequence
 	var builder strings.Builder
 	var err error
@@ -251,7 +635,10 @@ func (schema *Schema) generateNeededCreateSequenceStatements(target *Schema) (st
 		return "", err
 	}
 	for _, item := range sequences {
-		builder.WriteString(fmt.Sprintf("CREATE SEQUENCE \"%s\";\n", item))
+		builder.WriteString(item.CreateStatement())
+		if migration.options.syncSequences && item.lastValue.Valid {
+			builder.WriteString(item.SetValueStatement())
+		}
 	}
 	return builder.String(), nil
 }
@@ -282,6 +669,84 @@ func (schema *Schema) generateNeededDropTypeStatements(target *Schema) (string,
 	return builder.String(), nil
 }
 
+// findTypeDependents looks for the objects using the type in the database
+// of the schema, following arrays of the type too
+func (schema *Schema) findTypeDependents(item *Type) (*TypeDependents, error) {
+	var dependents TypeDependents
+	var rows *sql.Rows
+	var names []string
+	var err error
+	if rows, err = schema.db.Query(GetTypeColumns, item.oid); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var table string
+		var name string
+		var found *Table
+		if err = rows.Scan(&table, &name); err != nil {
+			return nil, err
+		}
+		if found = schema.FindTableByName(table); found == nil {
+			continue
+		}
+		for _, column := range found.columns {
+			if column.name == name {
+				dependents.columns = append(dependents.columns, column)
+			}
+		}
+	}
+	if rows, err = schema.db.Query(GetTypeViews, item.oid); err != nil {
+		return nil, err
+	}
+	if dependents.dropViews, dependents.views, names, err = viewStatements(rows); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if found := schema.FindTableByName(name); found != nil {
+			dependents.relations = append(dependents.relations, found)
+		}
+	}
+	if rows, err = schema.db.Query(GetTypeFunctions, item.oid); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var namespace string
+		var name string
+		var arguments string
+		var function *Function
+		if err = rows.Scan(&namespace, &name, &arguments); err != nil {
+			return nil, err
+		}
+		function = schema.FindFunctionBySignature(fmt.Sprintf("\"%s\"(%s)", name, arguments))
+		if namespace != schema.name || function == nil {
+			dependents.foreign = append(dependents.foreign, fmt.Sprintf("\"%s\".\"%s\"(%s)", namespace, name, arguments))
+		} else {
+			dependents.functions = append(dependents.functions, function)
+		}
+	}
+	return &dependents, nil
+}
+
+func (schema *Schema) examineIntersectingTypes(target *Schema, migration *Migration) (string, error) {
+	var builder strings.Builder
+	for _, item := range schema.types {
+		var found *Type
+		var dependents *TypeDependents
+		var err error
+		found = target.FindTypeByName(item.name)
+		if found == nil {
+			continue
+		}
+		if item.kind == Enum && found.kind == Enum && strings.Join(item.values, "\x00") != strings.Join(found.values, "\x00") {
+			if dependents, err = target.findTypeDependents(found); err != nil {
+				return "", err
+			}
+		}
+		builder.WriteString(item.Diff(found, dependents, migration))
+	}
+	return builder.String(), nil
+}
+
 func (schema *Schema) FindTypeByName(name string) *Type {
 	for _, item := range schema.types {
 		if strings.Compare(item.name, name) == 0 {
@@ -300,43 +765,132 @@ func (schema *Schema) FindTableByName(name string) *Table {
 	return nil
 }
 
-func (schema *Schema) Diff(target *Schema) (string, error) {
+func (schema *Schema) Diff(target *Schema, migration *Migration) error {
 	var err error
 	var builder strings.Builder
 	var tmp string
-	if tmp, err = schema.generateNeededCreateSequenceStatements(target); err != nil {
-		return "", err
+	migration.db = target.db
+	schema.mapRoles(migration.options.roles)
+	builder.WriteString(schema.localeWarnings(target))
+	if tmp, err = schema.examineExtensions(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineForeignServers(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineCollations(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.generateNeededCreateSequenceStatements(target, migration); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineIntersectingSequences(target, migration); err != nil {
+		return err
 	}
 	builder.WriteString(tmp)
 	if tmp, err = schema.generateNeededCreateTypeStatements(target); err != nil {
-		return "", err
+		return err
 	}
 	builder.WriteString(tmp)
 	if tmp, err = schema.generateNeededDropTypeStatements(target); err != nil {
-		return "", err
+		return err
 	}
 	builder.WriteString(tmp)
-	if tmp, err = schema.examineIntersectingTables(target); err != nil {
-		return "", err
+	if tmp, err = schema.examineIntersectingTypes(target, migration); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineFunctions(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineIntersectingTables(target, migration); err != nil {
+		return err
 	}
 	builder.WriteString(tmp)
 	if tmp, err = schema.generateNeededDropTableStatements(target); err != nil {
-		return "", err
+		return err
 	}
 	builder.WriteString(tmp)
 	if tmp, err = schema.generateNeededCreateTableStatements(target); err != nil {
-		return "", err
+		return err
 	}
 	builder.WriteString(tmp)
-	return builder.String(), nil
+	if tmp, err = schema.generateSequenceOwnershipStatements(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.generateNeededDropSequenceStatements(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineIntersectingViews(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	// Functions last, tables might still be using them until they are dropped
+	if tmp, err = schema.generateNeededDropFunctionStatements(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.generateNeededDropCollationStatements(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.generateNeededDropForeignServerStatements(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.generateNeededDropExtensionStatements(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examinePublications(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineComments(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examineOwners(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	if tmp, err = schema.examinePrivileges(target); err != nil {
+		return err
+	}
+	builder.WriteString(tmp)
+	migration.Write(Migrate, builder.String())
+	return nil
 }
 
 func buildSchema(db *sql.DB, catalog string, schemaName string) (*Schema, error) {
 	var schema Schema
 	var err error
+	schema.name = schemaName
+	if err = db.QueryRow(GetServerVersion).Scan(&schema.version); err != nil {
+		return nil, err
+	}
+	if err = schema.collectExtensions(db); err != nil {
+		return nil, err
+	}
+	if err = schema.collectForeignObjects(db); err != nil {
+		return nil, err
+	}
+	if err = schema.collectCollations(db, schemaName); err != nil {
+		return nil, err
+	}
 	if err = schema.collectTypes(db, schemaName); err != nil {
 		return nil, err
 	}
+	if err = schema.collectFunctions(db, schemaName); err != nil {
+		return nil, err
+	}
 	if err = schema.collectTables(db, catalog, schemaName); err != nil {
 		return nil, err
 	}
@@ -348,7 +902,25 @@ func buildSchema(db *sql.DB, catalog string, schemaName string) (*Schema, error)
 	if err = schema.collectConstraints(db); err != nil {
 		return nil, err
 	}
-	if err = schema.collectSequences(db); err != nil {
+	if err = schema.collectTriggers(db); err != nil {
+		return nil, err
+	}
+	if err = schema.collectPolicies(db); err != nil {
+		return nil, err
+	}
+	if err = schema.collectIndexes(db); err != nil {
+		return nil, err
+	}
+	if err = schema.collectDependents(db); err != nil {
+		return nil, err
+	}
+	if err = schema.collectSequences(db, schemaName); err != nil {
+		return nil, err
+	}
+	if err = schema.collectPrivileges(db, schemaName); err != nil {
+		return nil, err
+	}
+	if err = schema.collectPublications(db); err != nil {
 		return nil, err
 	}
 	return &schema, nil
@@ -358,16 +930,28 @@ func NewSchema(host string, port int16, user string, pass string, name string) (
 	var dsn string = fmt.Sprintf(DsnBase, host, port, user, name, pass)
 	var db *sql.DB
 	var err error
+	var schema *Schema
 	db, err = sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		err = db.Close()
-		if err != nil {
-			log.Println(err)
-			return
+	schema, err = buildSchema(db, name, "public")
+	if err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Println(closeErr)
 		}
-	}()
-	return buildSchema(db, name, "public")
+		return nil, err
+	}
+	// Kept open, the objects using enums and columns are read while
+	// diffing and defaults are compared by the server
+	schema.db = db
+	return schema, nil
+}
+
+func (schema *Schema) Close() {
+	var err error
+	err = schema.db.Close()
+	if err != nil {
+		log.Println(err)
+	}
 }
@@ -1,11 +1,164 @@
 package main
 
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// GetSequences lists every sequence of the schema except the ones backing
+// identity columns, those are part of the column definition, and the ones
+// created by extensions
+const GetSequences string = `
+SELECT
+  s.schemaname,
+  s.sequencename,
+  s.data_type::text,
+  s.start_value,
+  s.increment_by,
+  s.min_value,
+  s.max_value,
+  s.cache_size,
+  s.cycle,
+  s.last_value,
+  owner.relname,
+  owner.attname,
+  COALESCE(c.relacl, acldefault('s', c.relowner)),
+  pg_get_userbyid(c.relowner),
+  obj_description(c.oid, 'pg_class')
+FROM pg_catalog.pg_sequences s
+JOIN pg_catalog.pg_namespace n ON n.nspname = s.schemaname
+JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+LEFT JOIN LATERAL (
+  SELECT t.relname, a.attname
+  FROM pg_catalog.pg_depend d
+  JOIN pg_catalog.pg_class t ON t.oid = d.refobjid
+  JOIN pg_catalog.pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+  WHERE d.classid = 'pg_catalog.pg_class'::regclass AND
+    d.refclassid = 'pg_catalog.pg_class'::regclass AND
+    d.objid = c.oid AND
+    d.deptype = 'a'
+) owner ON TRUE
+WHERE s.schemaname = $1 AND
+  NOT EXISTS (
+    SELECT 1
+    FROM pg_catalog.pg_depend d
+    WHERE d.classid = 'pg_catalog.pg_class'::regclass AND
+      d.objid = c.oid AND
+      d.deptype IN ('i', 'e')
+  )
+ORDER BY s.sequencename
+`
+
 type Sequence struct {
-	name   string
-	column *Column
-	drops  bool
+	schema      string
+	name        string
+	column      *Column
+	drops       bool
+	dataType    string
+	start       int64
+	increment   int64
+	minimum     int64
+	maximum     int64
+	cache       int64
+	cycle       bool
+	lastValue   sql.NullInt64
+	ownerTable  sql.NullString
+	ownerColumn sql.NullString
+	acl         aclArray
+	owner       string
+	comment     sql.NullString
 }
 
 func (sequence Sequence) String() string {
 	return sequence.name
 }
+
+// QualifiedName is the quoted name of the sequence including its schema,
+// as used in nextval('...'::regclass)
+func (sequence *Sequence) QualifiedName() string {
+	var name string = strings.ReplaceAll(sequence.name, "\"", "\"\"")
+	if sequence.schema == "" {
+		return fmt.Sprintf("\"%s\"", name)
+	}
+	return fmt.Sprintf("\"%s\".\"%s\"", strings.ReplaceAll(sequence.schema, "\"", "\"\""), name)
+}
+
+func (sequence *Sequence) Equal(other *Sequence) bool {
+	return sequence.schema == other.schema && sequence.name == other.name
+}
+
+func (sequence *Sequence) collect(rows *sql.Rows) error {
+	return rows.Scan(
+		&sequence.schema,
+		&sequence.name,
+		&sequence.dataType,
+		&sequence.start,
+		&sequence.increment,
+		&sequence.minimum,
+		&sequence.maximum,
+		&sequence.cache,
+		&sequence.cycle,
+		&sequence.lastValue,
+		&sequence.ownerTable,
+		&sequence.ownerColumn,
+		&sequence.acl,
+		&sequence.owner,
+		&sequence.comment,
+	)
+}
+
+func (sequence *Sequence) options() string {
+	var cycle string = "NO CYCLE"
+	if sequence.cycle {
+		cycle = "CYCLE"
+	}
+	return fmt.Sprintf(
+		"AS %s INCREMENT BY %d MINVALUE %d MAXVALUE %d START WITH %d CACHE %d %s",
+		sequence.dataType,
+		sequence.increment,
+		sequence.minimum,
+		sequence.maximum,
+		sequence.start,
+		sequence.cache,
+		cycle,
+	)
+}
+
+func (sequence *Sequence) CreateStatement() string {
+	return fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS \"%s\" %s;\n", sequence.name, sequence.options())
+}
+
+func (sequence *Sequence) DropStatement() string {
+	return fmt.Sprintf("DROP SEQUENCE IF EXISTS \"%s\";\n", sequence.name)
+}
+
+// OwnedByStatement ties the sequence to its column, it can only run once
+// the table exists
+func (sequence *Sequence) OwnedByStatement() string {
+	if !sequence.ownerTable.Valid {
+		return fmt.Sprintf("ALTER SEQUENCE \"%s\" OWNED BY NONE;\n", sequence.name)
+	}
+	return fmt.Sprintf(
+		"ALTER SEQUENCE \"%s\" OWNED BY \"%s\".\"%s\";\n",
+		sequence.name,
+		sequence.ownerTable.String,
+		sequence.ownerColumn.String,
+	)
+}
+
+func (sequence *Sequence) SetValueStatement() string {
+	return fmt.Sprintf("SELECT setval('\"%s\"', %d, true);\n", sequence.name, sequence.lastValue.Int64)
+}
+
+func (sequence *Sequence) Diff(target *Sequence) string {
+	if sequence.options() == target.options() {
+		return ""
+	}
+	return fmt.Sprintf("ALTER SEQUENCE \"%s\" %s;\n", sequence.name, sequence.options())
+}
+
+func (sequence *Sequence) ownerEqual(target *Sequence) bool {
+	return strings.Compare(sequence.ownerTable.String, target.ownerTable.String) == 0 &&
+		strings.Compare(sequence.ownerColumn.String, target.ownerColumn.String) == 0
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+var storageNames map[string]string = map[string]string{
+	"p": "PLAIN",
+	"e": "EXTERNAL",
+	"m": "MAIN",
+	"x": "EXTENDED",
+}
+
+var compressionNames map[string]string = map[string]string{
+	"p": "pglz",
+	"l": "lz4",
+}
+
+func optionName(option string) string {
+	return strings.SplitN(option, "=", 2)[0]
+}
+
+// optionsDiff returns the storage parameters to SET and the names of the
+// ones to RESET in order to turn current into wanted
+func optionsDiff(wanted []string, current []string) ([]string, []string) {
+	var set []string
+	var reset []string
+	var names []string
+	for _, option := range wanted {
+		names = append(names, optionName(option))
+		if indexOf(current, option) == -1 {
+			set = append(set, option)
+		}
+	}
+	for _, option := range current {
+		if indexOf(names, optionName(option)) == -1 {
+			reset = append(reset, optionName(option))
+		}
+	}
+	return set, reset
+}
+
+// tablespaceClause is empty for the default tablespace of the database
+func (table *Table) tablespaceClause() string {
+	if !table.tablespace.Valid {
+		return ""
+	}
+	return fmt.Sprintf(" TABLESPACE \"%s\"", table.tablespace.String)
+}
+
+// storageClauses are the WITH and TABLESPACE clauses of CREATE TABLE
+func (table *Table) storageClauses() string {
+	var builder strings.Builder
+	if len(table.options) > 0 {
+		builder.WriteString(fmt.Sprintf(" WITH (%s)", strings.Join(table.options, ", ")))
+	}
+	builder.WriteString(table.tablespaceClause())
+	return builder.String()
+}
+
+// SetTablespaceStatement moves the table, an invalid tablespace is the
+// default one of the database
+func (table *Table) SetTablespaceStatement(tablespace sql.NullString) string {
+	var name string = "pg_default"
+	var impact Impact = FullRewrite
+	if tablespace.Valid {
+		name = fmt.Sprintf("\"%s\"", tablespace.String)
+	}
+	if table.partitionKey.Valid {
+		// Only the default for new partitions changes
+		impact = MetadataOnly
+	}
+	return table.annotate(
+		fmt.Sprintf("ALTER %s \"%s\" SET TABLESPACE %s;\n", table.keyword(), table.name, name),
+		AccessExclusive,
+		impact,
+	)
+}
+
+// storageDiff compares the storage parameters, tablespace and persistence
+// of the tables, the statements are annotated with the target table
+// optionsStatements sets and resets the storage parameters of the target
+// relation so that they match the ones of this relation
+func (table *Table) optionsStatements(target *Table) string {
+	var builder strings.Builder
+	var set []string
+	var reset []string
+	set, reset = optionsDiff(table.options, target.options)
+	if len(set) > 0 {
+		builder.WriteString(target.annotate(
+			fmt.Sprintf("ALTER %s \"%s\" SET (%s);\n", table.keyword(), table.name, strings.Join(set, ", ")),
+			ShareUpdateExclusive,
+			MetadataOnly,
+		))
+	}
+	if len(reset) > 0 {
+		builder.WriteString(target.annotate(
+			fmt.Sprintf("ALTER %s \"%s\" RESET (%s);\n", table.keyword(), table.name, strings.Join(reset, ", ")),
+			ShareUpdateExclusive,
+			MetadataOnly,
+		))
+	}
+	return builder.String()
+}
+
+func (table *Table) storageDiff(target *Table) string {
+	var builder strings.Builder
+	builder.WriteString(table.optionsStatements(target))
+	if table.tablespace != target.tablespace {
+		builder.WriteString(target.SetTablespaceStatement(table.tablespace))
+	}
+	if table.persistence != target.persistence {
+		var persistence string = "LOGGED"
+		if table.persistence == "u" {
+			persistence = "UNLOGGED"
+		}
+		builder.WriteString(target.annotate(
+			fmt.Sprintf("ALTER TABLE \"%s\" SET %s;\n", table.name, persistence),
+			AccessExclusive,
+			FullRewrite,
+		))
+	}
+	return builder.String()
+}
+
+// storageOptions lists the SET clauses of ALTER COLUMN needed to give the
+// column its storage, compression and statistics target, a nil current
+// column stands for the defaults of a new column
+func (column *Column) storageOptions(current *Column) []string {
+	var options []string
+	var storage string = column.typeStorage
+	var compression string
+	var statistics int64 = -1
+	if current != nil {
+		storage = current.storage
+		compression = current.compression.String
+		statistics = current.statistics.Int64
+		if !current.statistics.Valid {
+			statistics = -1
+		}
+	}
+	if column.storage != storage {
+		options = append(options, fmt.Sprintf("STORAGE %s", storageNames[column.storage]))
+	}
+	if column.compression.String != compression {
+		if name, found := compressionNames[column.compression.String]; found {
+			options = append(options, fmt.Sprintf("COMPRESSION %s", name))
+		} else {
+			options = append(options, "COMPRESSION DEFAULT")
+		}
+	}
+	if column.statistics.Valid && column.statistics.Int64 != statistics {
+		options = append(options, fmt.Sprintf("STATISTICS %d", column.statistics.Int64))
+	} else if !column.statistics.Valid && statistics != -1 {
+		options = append(options, "STATISTICS -1")
+	}
+	return options
+}
+
+func (column *Column) storageStatement(options []string) string {
+	var list []string
+	for _, option := range options {
+		list = append(list, fmt.Sprintf("ALTER COLUMN \"%s\" SET %s", column.name, option))
+	}
+	return fmt.Sprintf("ALTER TABLE \"%s\" %s;\n", column.table.name, strings.Join(list, ", "))
+}
+
+// storageDiff turns the storage options of the column into the ones of
+// target, only the statistics target can be changed without blocking reads
+func (column *Column) storageDiff(target *Column) string {
+	var options []string = target.storageOptions(column)
+	var mode LockMode = ShareUpdateExclusive
+	if len(options) == 0 {
+		return ""
+	}
+	for _, option := range options {
+		if !strings.HasPrefix(option, "STATISTICS") {
+			mode = AccessExclusive
+		}
+	}
+	return column.table.annotate(column.storageStatement(options), mode, MetadataOnly)
+}
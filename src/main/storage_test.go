@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionsDiff(t *testing.T) {
+	var tests = []struct {
+		name    string
+		wanted  []string
+		current []string
+		set     string
+		reset   string
+	}{
+		{"same", []string{"fillfactor=70"}, []string{"fillfactor=70"}, "", ""},
+		{"added", []string{"fillfactor=70"}, nil, "fillfactor=70", ""},
+		{"changed", []string{"fillfactor=70"}, []string{"fillfactor=90"}, "fillfactor=70", ""},
+		{"removed", nil, []string{"fillfactor=90", "autovacuum_enabled=false"}, "", "fillfactor, autovacuum_enabled"},
+		{
+			"mixed",
+			[]string{"fillfactor=70", "toast_tuple_target=256"},
+			[]string{"fillfactor=90", "autovacuum_enabled=false"},
+			"fillfactor=70, toast_tuple_target=256",
+			"autovacuum_enabled",
+		},
+	}
+	for _, test := range tests {
+		var set []string
+		var reset []string
+		set, reset = optionsDiff(test.wanted, test.current)
+		if strings.Join(set, ", ") != test.set || strings.Join(reset, ", ") != test.reset {
+			t.Logf("%s: expected SET (%s) RESET (%s), got SET %q RESET %q", test.name, test.set, test.reset, set, reset)
+			t.Fail()
+		}
+	}
+}
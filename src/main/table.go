@@ -3,38 +3,184 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"regexp"
 	"strings"
 )
 
 const GetTables string = `
 SELECT
-  information_schema.tables.table_name,
-  information_schema.tables.table_type,
-  information_schema.tables.table_schema,
-  information_schema.tables.table_catalog,
-  information_schema.views.view_definition
+  information_schema.tables.table_name::text,
+  information_schema.tables.table_type::text,
+  information_schema.tables.table_schema::text,
+  information_schema.tables.table_catalog::text,
+  pg_get_viewdef(pg_class.oid),
+  GREATEST(COALESCE(pg_class.reltuples, 0), 0)::bigint,
+  COALESCE(pg_relation_size(pg_class.oid), 0),
+  TRUE,
+  pg_class.reloptions,
+  pg_get_partkeydef(pg_class.oid),
+  (
+    SELECT parent.relname::text
+    FROM pg_catalog.pg_inherits
+    JOIN pg_catalog.pg_class parent ON parent.oid = pg_inherits.inhparent
+    WHERE pg_inherits.inhrelid = pg_class.oid AND
+      pg_class.relispartition
+  ),
+  pg_get_expr(pg_class.relpartbound, pg_class.oid),
+  ARRAY(
+    SELECT parent.relname::text
+    FROM pg_catalog.pg_inherits
+    JOIN pg_catalog.pg_class parent ON parent.oid = pg_inherits.inhparent
+    WHERE pg_inherits.inhrelid = pg_class.oid AND
+      NOT pg_class.relispartition
+    ORDER BY pg_inherits.inhseqno
+  ),
+  COALESCE(pg_class.relrowsecurity, FALSE),
+  COALESCE(pg_class.relforcerowsecurity, FALSE),
+  COALESCE(pg_class.relacl, acldefault('r', pg_class.relowner)),
+  pg_get_userbyid(pg_class.relowner),
+  obj_description(pg_class.oid, 'pg_class'),
+  (
+    SELECT spcname::text
+    FROM pg_catalog.pg_tablespace
+    WHERE pg_tablespace.oid = pg_class.reltablespace
+  ),
+  COALESCE(pg_class.relpersistence::text, 'p'),
+  (
+    SELECT pg_foreign_server.srvname::text
+    FROM pg_catalog.pg_foreign_table
+    JOIN pg_catalog.pg_foreign_server
+      ON pg_foreign_server.oid = pg_foreign_table.ftserver
+    WHERE pg_foreign_table.ftrelid = pg_class.oid
+  ),
+  (
+    SELECT pg_foreign_table.ftoptions
+    FROM pg_catalog.pg_foreign_table
+    WHERE pg_foreign_table.ftrelid = pg_class.oid
+  )
 FROM information_schema.tables
 NATURAL LEFT JOIN information_schema.views
+LEFT JOIN pg_catalog.pg_namespace
+  ON pg_namespace.nspname = information_schema.tables.table_schema
+LEFT JOIN pg_catalog.pg_class
+  ON pg_class.relnamespace = pg_namespace.oid AND
+  pg_class.relname = information_schema.tables.table_name
 WHERE information_schema.tables.table_catalog = $1 AND
-  information_schema.tables.table_schema = $2
+  information_schema.tables.table_schema = $2 AND
+  NOT EXISTS (
+    SELECT 1
+    FROM pg_catalog.pg_depend d
+    WHERE d.classid = 'pg_catalog.pg_class'::regclass AND
+      d.objid = pg_class.oid AND
+      d.deptype = 'e'
+  )
+UNION ALL
+SELECT
+  pg_matviews.matviewname::text,
+  'MATERIALIZED VIEW',
+  pg_matviews.schemaname::text,
+  current_database()::text,
+  pg_matviews.definition,
+  GREATEST(pg_class.reltuples, 0)::bigint,
+  pg_relation_size(pg_class.oid),
+  pg_matviews.ispopulated,
+  pg_class.reloptions,
+  NULL,
+  NULL,
+  NULL,
+  NULL,
+  FALSE,
+  FALSE,
+  COALESCE(pg_class.relacl, acldefault('r', pg_class.relowner)),
+  pg_get_userbyid(pg_class.relowner),
+  obj_description(pg_class.oid, 'pg_class'),
+  (
+    SELECT spcname::text
+    FROM pg_catalog.pg_tablespace
+    WHERE pg_tablespace.oid = pg_class.reltablespace
+  ),
+  COALESCE(pg_class.relpersistence::text, 'p'),
+  NULL,
+  NULL
+FROM pg_catalog.pg_matviews
+JOIN pg_catalog.pg_namespace
+  ON pg_namespace.nspname = pg_matviews.schemaname
+JOIN pg_catalog.pg_class
+  ON pg_class.relnamespace = pg_namespace.oid AND
+  pg_class.relname = pg_matviews.matviewname
+WHERE current_database() = $1 AND
+  pg_matviews.schemaname = $2 AND
+  NOT EXISTS (
+    SELECT 1
+    FROM pg_catalog.pg_depend d
+    WHERE d.classid = 'pg_catalog.pg_class'::regclass AND
+      d.objid = pg_class.oid AND
+      d.deptype = 'e'
+  )
+`
+
+// GetDependentViews lists the views and materialized views that would be
+// dropped along with the relation by DROP ... CASCADE
+const GetDependentViews string = `
+SELECT DISTINCT v.relname
+FROM pg_catalog.pg_depend d
+JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+JOIN pg_catalog.pg_class v ON v.oid = r.ev_class
+WHERE d.classid = 'pg_catalog.pg_rewrite'::regclass AND
+  d.refobjid = quote_ident($1)::regclass AND
+  v.oid <> d.refobjid
+ORDER BY v.relname
 `
 
 type TableType string
 
 const (
-	BaseTable TableType = "BASE TABLE"
-	View                = "VIEW"
+	BaseTable        TableType = "BASE TABLE"
+	View                       = "VIEW"
+	MaterializedView           = "MATERIALIZED VIEW"
+	ForeignTable               = "FOREIGN"
 )
 
 type Table struct {
 	name           string
 	constraints    []*Constraint
 	columns        []*Column
+	triggers       []*Trigger
 	kind           TableType
 	schema         string
 	catalog        string
 	viewDefinition string
+	estimatedRows  int64
+	size           int64
+	populated      bool
+	options        stringArray
+	indexes        []*Index
+	dependents     []string
+	// Set on views of the target dropped to alter the tables they use
+	dropped bool
+	// Set on relations of the target dropped and created again, they come
+	// back with the privileges, owner and comments of a new relation
+	recreated bool
+	// Set on partitioned tables, including the strategy, e.g. RANGE (id)
+	partitionKey sql.NullString
+	// Set on partitions, the parent table and the FOR VALUES clause
+	partitionOf    sql.NullString
+	partitionBound sql.NullString
+	// Parents of the table when using old style inheritance
+	inherits stringArray
+	// Row level security
+	rowSecurity      bool
+	forceRowSecurity bool
+	policies         []*Policy
+	acl              aclArray
+	owner            string
+	comment          sql.NullString
+	// Only set outside of the default tablespace of the database
+	tablespace sql.NullString
+	// One of "p" (permanent), "u" (unlogged) or "t" (temporary)
+	persistence string
+	// Server and options of foreign tables
+	server         sql.NullString
+	foreignOptions stringArray
 }
 
 func (table *Table) FindColumn(search *Column) *Column {
@@ -73,6 +219,10 @@ func (table *Table) columnSetDifference(other *Table) ([]*Column, error) {
 	var difference []*Column
 	for _, column := range table.columns {
 		var found *Column
+		if !column.isLocal {
+			// Added and removed with the parent
+			continue
+		}
 		found = other.FindColumn(column)
 		if found == nil {
 			difference = append(difference, column)
@@ -90,11 +240,23 @@ func (table *Table) GetPrimaryKey() *Constraint {
 	return nil
 }
 
+func (table *Table) FindConstraintByName(name string) *Constraint {
+	for _, constraint := range table.constraints {
+		if constraint.name == name {
+			return constraint
+		}
+	}
+	return nil
+}
+
 func (table *Table) constraintSetDifference(target *Table) ([]*Constraint, error) {
 	var constraints []*Constraint
 	var err error
 	for _, constraint := range table.constraints {
 		var found bool
+		if !constraint.isLocal {
+			continue
+		}
 		found, err = target.hasConstraint(constraint)
 		if err != nil {
 			return nil, err
@@ -110,6 +272,8 @@ func (table *Table) collectColumns(db *sql.DB) error {
 	var defaultValue interface{}
 	var err error
 	var sequence *Sequence
+	var sequenceSchema sql.NullString
+	var sequenceName sql.NullString
 	// First list the columns
 	rows, err = db.Query(GetColumns, table.name, table.catalog, table.schema)
 	if err != nil {
@@ -124,16 +288,33 @@ func (table *Table) collectColumns(db *sql.DB) error {
 			&defaultValue,
 			&nullable,
 			&column.dataType,
-			&column.length,
-			&column.numericPrecision,
-			&column.numericScale,
+			&column.baseType,
+			&column.typmod,
+			&column.isLocal,
+			&column.collation,
+			&column.identity,
+			&column.identityStart,
+			&column.identityIncrement,
+			&column.identityMinimum,
+			&column.identityMaximum,
+			&column.identityCycle,
+			&column.generationExpression,
+			&sequenceSchema,
+			&sequenceName,
+			&column.comment,
+			&column.storage,
+			&column.typeStorage,
+			&column.compression,
+			&column.statistics,
+			&column.fdwOptions,
+			&column.volatileDefault,
 		)
 		if err != nil {
 			return err
 		}
 		column.isNullable = nullable != "NO"
 		if defaultValue != nil {
-			sequence = getSequenceIfAny(defaultValue.(string), column)
+			sequence = getSequenceIfAny(defaultValue.(string), sequenceSchema, sequenceName, column)
 			if sequence == nil {
 				column.defaultValue = defaultValue
 			} else {
@@ -147,18 +328,20 @@ func (table *Table) collectColumns(db *sql.DB) error {
 	return nil
 }
 
-func getSequenceIfAny(value string, column *Column) *Sequence {
-	var list [][]string
-	var re *regexp.Regexp
+// getSequenceIfAny returns the sequence the default value is taken from,
+// the sequence comes from the dependency of the default on it, so it is
+// only needed to check that the default is nothing more than nextval()
+func getSequenceIfAny(value string, schema sql.NullString, name sql.NullString, column *Column) *Sequence {
 	var sequence Sequence
-	re = regexp.MustCompile("nextval\\('\"([^\"]+)\"'::regclass\\)")
-	list = re.FindAllStringSubmatch(value, -1)
-	if len(list) == 1 {
-		sequence.column = column
-		sequence.name = list[0][1]
-	} else {
+	if !name.Valid {
+		return nil
+	}
+	if !strings.HasPrefix(value, "nextval(") || !strings.HasSuffix(value, "::regclass)") {
 		return nil
 	}
+	sequence.column = column
+	sequence.schema = schema.String
+	sequence.name = name.String
 	return &sequence
 }
 
@@ -176,39 +359,258 @@ func (table *Table) hasConstraint(target *Constraint) (bool, error) {
 	return false, nil
 }
 
+func (table *Table) collectDependents(db *sql.DB) error {
+	var rows *sql.Rows
+	var err error
+	if rows, err = db.Query(GetDependentViews, table.name); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		table.dependents = append(table.dependents, name)
+	}
+	return nil
+}
+
+func (table *Table) keyword() string {
+	if table.kind == MaterializedView {
+		return "MATERIALIZED VIEW"
+	} else if table.kind == View {
+		return "VIEW"
+	} else if table.kind == ForeignTable {
+		return "FOREIGN TABLE"
+	}
+	return "TABLE"
+}
+
+func (table *Table) isView() bool {
+	return table.kind == View || table.kind == MaterializedView
+}
+
 func (table *Table) DropStatement() string {
-	if table.kind == View {
+	if table.kind == MaterializedView {
+		return fmt.Sprintf("DROP MATERIALIZED VIEW IF EXISTS \"%s\" CASCADE;\n", table.name)
+	} else if table.kind == View {
 		return fmt.Sprintf("DROP VIEW IF EXISTS \"%s\" CASCADE;\n", table.name)
 	} else {
-		return fmt.Sprintf("DROP TABLE IF EXISTS \"%s\";\n", table.name)
+		return table.annotate(
+			fmt.Sprintf("DROP %s IF EXISTS \"%s\";\n", table.keyword(), table.name),
+			AccessExclusive,
+			MetadataOnly,
+		)
 	}
 }
 
 func (table *Table) CreateStatement() string {
-	if table.kind == View {
-		return fmt.Sprintf("CREATE OR REPLACE VIEW \"%s\" AS (\n  %s\n);\n", table.name, table.viewDefinition)
+	if table.kind == MaterializedView {
+		var builder strings.Builder
+		builder.WriteString(fmt.Sprintf("CREATE MATERIALIZED VIEW \"%s\"", table.name))
+		builder.WriteString(table.storageClauses())
+		// Populate it once the indexes exist
+		builder.WriteString(fmt.Sprintf(" AS\n  %s\nWITH NO DATA;\n", table.viewDefinition))
+		for _, index := range table.indexes {
+			builder.WriteString(index.CreateStatement())
+		}
+		if table.populated {
+			builder.WriteString(table.RefreshStatement())
+		}
+		return builder.String()
+	} else if table.kind == View {
+		var builder strings.Builder
+		builder.WriteString(table.ReplaceViewStatement())
+		for _, trigger := range table.triggers {
+			builder.WriteString(trigger.CreateStatement())
+		}
+		return builder.String()
+	} else if table.kind == ForeignTable && !table.partitionOf.Valid {
+		return table.CreateForeignTableStatement()
 	} else {
 		var list []string
 		for _, column := range table.columns {
-			list = append(list, column.String())
+			if column.isLocal {
+				list = append(list, column.String())
+			}
 		}
 		// Add the constraints
 		for _, constraint := range table.constraints {
-			list = append(list, constraint.String())
+			if constraint.isLocal {
+				list = append(list, constraint.String())
+			}
+		}
+		var builder strings.Builder
+		var unlogged string
+		if table.partitionOf.Valid {
+			// Columns and constraints come from the parent
+			return table.CreatePartitionStatement()
+		}
+		if table.persistence == "u" {
+			unlogged = "UNLOGGED "
+		}
+		builder.WriteString(
+			fmt.Sprintf("CREATE %sTABLE \"%s\" (\n  %s\n)", unlogged, table.name, strings.Join(list, ",\n  ")),
+		)
+		if len(table.inherits) > 0 {
+			builder.WriteString(fmt.Sprintf(" INHERITS (\"%s\")", strings.Join(table.inherits, "\", \"")))
+		}
+		if table.partitionKey.Valid {
+			builder.WriteString(fmt.Sprintf(" PARTITION BY %s", table.partitionKey.String))
+		}
+		builder.WriteString(table.storageClauses())
+		builder.WriteString(";\n")
+		for _, column := range table.columns {
+			if options := column.storageOptions(nil); column.isLocal && len(options) > 0 {
+				builder.WriteString(column.storageStatement(options))
+			}
 		}
-		return fmt.Sprintf("CREATE TABLE \"%s\" (\n  %s\n);\n", table.name, strings.Join(list, ",\n  "))
+		for _, trigger := range table.triggers {
+			builder.WriteString(trigger.CreateStatement())
+		}
+		if table.rowSecurity || table.forceRowSecurity {
+			builder.WriteString(table.rowSecurityStatements())
+		}
+		for _, policy := range table.policies {
+			builder.WriteString(policy.CreateStatement())
+		}
+		return builder.String()
 	}
 }
 
+// ReplaceViewStatement changes the definition of the view in place, which
+// keeps its triggers
+func (table *Table) ReplaceViewStatement() string {
+	var options string
+	if len(table.options) > 0 {
+		options = fmt.Sprintf(" WITH (%s)", strings.Join(table.options, ", "))
+	}
+	return fmt.Sprintf("CREATE OR REPLACE VIEW \"%s\"%s AS (\n  %s\n);\n", table.name, options, table.viewDefinition)
+}
+
+func (table *Table) RefreshStatement() string {
+	return fmt.Sprintf("REFRESH MATERIALIZED VIEW \"%s\";\n", table.name)
+}
+
+// materializedViewDiff returns true if the materialized view has to be
+// recreated, otherwise the statements needed to update the target
+func (table *Table) materializedViewDiff(target *Table) (bool, string) {
+	var builder strings.Builder
+	if table.viewDefinition != target.viewDefinition {
+		return true, ""
+	}
+	builder.WriteString(table.optionsStatements(target))
+	if table.tablespace != target.tablespace {
+		builder.WriteString(target.SetTablespaceStatement(table.tablespace))
+	}
+	builder.WriteString(table.indexDiff(target))
+	if table.populated && !target.populated {
+		builder.WriteString(table.RefreshStatement())
+	}
+	return false, builder.String()
+}
+
+// viewColumnsCompatible tells whether CREATE OR REPLACE VIEW can turn the
+// target view into this one, which is only possible if every column of
+// the target is kept in the same position with the same type
+func (table *Table) viewColumnsCompatible(target *Table) bool {
+	for _, column := range target.columns {
+		var found *Column
+		found = table.FindColumnByPosition(column.position)
+		if found == nil || found.name != column.name || found.dataType != column.dataType {
+			return false
+		}
+	}
+	return true
+}
+
+// viewDiff returns true if the view has to be dropped and created again,
+// otherwise the statements needed to update the target
+func (table *Table) viewDiff(target *Table) (bool, string) {
+	if table.viewDefinition == target.viewDefinition &&
+		strings.Join(table.options, ",") == strings.Join(target.options, ",") {
+		return false, ""
+	}
+	if !table.viewColumnsCompatible(target) {
+		return true, ""
+	}
+	return false, table.ReplaceViewStatement()
+}
+
 func (table *Table) AddColumnStatement(column *Column) string {
-	return fmt.Sprintf("ALTER TABLE \"%s\" ADD COLUMN %v;\n", table.name, column)
+	var impact Impact = MetadataOnly
+	if _, sequence := column.defaultValue.(*Sequence); sequence || column.volatileDefault {
+		// A volatile default has to be evaluated for every existing row
+		impact = FullRewrite
+	} else if column.identity.Valid || column.generationExpression.Valid {
+		impact = FullRewrite
+	}
+	return table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" ADD COLUMN %v;\n", table.name, column),
+		AccessExclusive,
+		impact,
+	)
 }
 
 func (table *Table) DropColumnStatement(column *Column) string {
-	return fmt.Sprintf("ALTER TABLE \"%s\" DROP COLUMN IF EXISTS \"%s\";\n", table.name, column.name)
+	return table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" DROP COLUMN IF EXISTS \"%s\";\n", table.name, column.name),
+		AccessExclusive,
+		MetadataOnly,
+	)
 }
 
-func (table *Table) columnDiff(target *Table) (string, error) {
+func (table *Table) AddConstraintStatement(constraint *Constraint) string {
+	var mode LockMode = AccessExclusive
+	if constraint.kind == ForeignKey {
+		// Both the referencing and the referenced table are locked
+		mode = ShareRowExclusive
+	}
+	// FIXME: generate constraint creation code
+	return constraint.annotateReferenced(table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" ADD CONSTRAINT \"%s\" %s;\n", table.name, constraint.name, constraint),
+		mode,
+		FullScan,
+	))
+}
+
+// AddConstraintNotValidStatement adds the constraint without checking the
+// existing rows, so only a brief lock is taken; the constraint must then be
+// validated with ValidateConstraintStatement
+func (table *Table) AddConstraintNotValidStatement(constraint *Constraint) string {
+	var mode LockMode = AccessExclusive
+	if constraint.kind == ForeignKey {
+		mode = ShareRowExclusive
+	}
+	return constraint.annotateReferenced(table.annotate(
+		fmt.Sprintf(
+			"ALTER TABLE \"%s\" ADD CONSTRAINT \"%s\" %s NOT VALID;\n",
+			table.name,
+			constraint.name,
+			constraint,
+		),
+		mode,
+		MetadataOnly,
+	))
+}
+
+func (table *Table) ValidateConstraintStatement(name string) string {
+	return table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" VALIDATE CONSTRAINT \"%s\";\n", table.name, name),
+		ShareUpdateExclusive,
+		FullScan,
+	)
+}
+
+func (table *Table) DropConstraintStatement(constraint *Constraint) string {
+	return table.annotate(
+		fmt.Sprintf("ALTER TABLE \"%s\" DROP CONSTRAINT IF EXISTS \"%s\";\n", table.name, constraint.name),
+		AccessExclusive,
+		MetadataOnly,
+	)
+}
+
+func (table *Table) columnDiff(target *Table, migration *Migration) (string, error) {
 	var builder strings.Builder
 	for _, column := range target.columns {
 		var result string
@@ -216,10 +618,14 @@ func (table *Table) columnDiff(target *Table) (string, error) {
 		var other *Column
 		other = table.FindColumn(column)
 		if other == nil {
-			// The entire column does not exist
-			return "", nil
+			// The entire column does not exist, it is dropped later
+			continue
+		}
+		if !column.isLocal && !other.isLocal {
+			// Altered through the parent
+			continue
 		}
-		result, err = column.Diff(other)
+		result, err = column.Diff(other, migration)
 		if err != nil {
 			return "", err
 		}
@@ -228,7 +634,23 @@ func (table *Table) columnDiff(target *Table) (string, error) {
 	return builder.String(), nil
 }
 
-func (table *Table) Diff(target *Table) (string, error) {
+// breaksViews tells whether the diff drops or retypes columns of the
+// target in place, which fails while views use them, the online type
+// change takes care of the views by itself
+func (table *Table) breaksViews(target *Table, migration *Migration) bool {
+	for _, column := range target.columns {
+		var other *Column = table.FindColumn(column)
+		if other == nil || other.collation != column.collation {
+			return true
+		}
+		if other.dataType != column.dataType && !(migration.options.online && column.typeChangeImpact(other) == FullRewrite) {
+			return true
+		}
+	}
+	return false
+}
+
+func (table *Table) Diff(target *Table, migration *Migration) (string, error) {
 	var err error
 	var constraints []*Constraint
 	var columns []*Column
@@ -241,14 +663,43 @@ func (table *Table) Diff(target *Table) (string, error) {
 	if table.kind == View {
 		return "", nil
 	}
+	builder.WriteString(table.partitionDiff(target))
+	builder.WriteString(table.inheritanceDiff(target))
+	builder.WriteString(table.storageDiff(target))
+	if table.kind == ForeignTable && table.server != target.server {
+		// The server of a foreign table cannot be changed
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: foreign table \"%s\" moves from server \"%s\" to \"%s\", it is dropped and created again, objects using it are lost\n",
+				table.name,
+				target.server.String,
+				table.server.String,
+			),
+		)
+		builder.WriteString(target.DropStatement())
+		builder.WriteString(table.CreateStatement())
+		target.recreated = true
+		return builder.String(), nil
+	} else if table.kind == ForeignTable {
+		builder.WriteString(table.foreignTableDiff(target))
+	}
+	if table.partitionOf.Valid && table.partitionOf == target.partitionOf {
+		// Columns and constraints of a partition follow the parent,
+		// only its own triggers are left
+		builder.WriteString(table.triggerDiff(target))
+		return builder.String(), nil
+	}
 	// Generate add column for new/columns columns
 	if columns, err = table.columnSetDifference(target); err != nil {
 		return "", err
 	}
 	for _, column := range columns {
-		builder.WriteString(table.AddColumnStatement(column))
+		builder.WriteString(target.AddColumnStatement(column))
+		if options := column.storageOptions(nil); len(options) > 0 {
+			builder.WriteString(target.annotate(column.storageStatement(options), AccessExclusive, MetadataOnly))
+		}
 	}
-	if tmp, err = table.columnDiff(target); err != nil {
+	if tmp, err = table.columnDiff(target, migration); err != nil {
 		return "", err
 	}
 	builder.WriteString(tmp)
@@ -256,22 +707,28 @@ func (table *Table) Diff(target *Table) (string, error) {
 		return "", err
 	}
 	for _, constraint := range constraints {
-		// FIXME: generate constraint creation code
-		builder.WriteString(fmt.Sprintf("ALTER TABLE \"%s\" ADD CONSTRAINT \"%s\" %s;\n", table.name, constraint.name, constraint))
+		if migration.options.online && constraint.kind == ForeignKey {
+			builder.WriteString(target.AddConstraintNotValidStatement(constraint))
+			migration.Write(Validate, target.ValidateConstraintStatement(constraint.name))
+		} else {
+			builder.WriteString(target.AddConstraintStatement(constraint))
+		}
 	}
 	if constraints, err = target.constraintSetDifference(table); err != nil {
 		return "", err
 	}
 	for _, constraint := range constraints {
-		builder.WriteString(fmt.Sprintf("ALTER TABLE \"%s\" DROP CONSTRAINT IF EXISTS \"%s\";\n", table.name, constraint.name))
+		builder.WriteString(target.DropConstraintStatement(constraint))
 	}
 	// Generate drop obsolete columns
 	if columns, err = target.columnSetDifference(table); err != nil {
 		return "", err
 	}
 	for _, column := range columns {
-		builder.WriteString(table.DropColumnStatement(column))
+		builder.WriteString(target.DropColumnStatement(column))
 	}
+	builder.WriteString(table.triggerDiff(target))
+	builder.WriteString(table.policyDiff(target))
 	// Add new/missing constraints
 	if constraints, err = table.constraintSetDifference(target); err != nil {
 		return "", err
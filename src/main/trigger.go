@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const GetTriggers string = `
+SELECT
+  t.tgname,
+  CASE
+    WHEN t.tgtype & 2 <> 0 THEN 'BEFORE'
+    WHEN t.tgtype & 64 <> 0 THEN 'INSTEAD OF'
+    ELSE 'AFTER'
+  END,
+  ARRAY_REMOVE(ARRAY[
+    CASE WHEN t.tgtype & 4 <> 0 THEN 'INSERT' END,
+    CASE WHEN t.tgtype & 16 <> 0 THEN 'UPDATE' END,
+    CASE WHEN t.tgtype & 8 <> 0 THEN 'DELETE' END,
+    CASE WHEN t.tgtype & 32 <> 0 THEN 'TRUNCATE' END
+  ], NULL),
+  t.tgtype & 1 <> 0,
+  substring(pg_get_triggerdef(t.oid) FROM 'WHEN \((.*)\) EXECUTE'),
+  ARRAY(
+    SELECT a.attname
+    FROM unnest(t.tgattr::int2[]) WITH ORDINALITY AS k(attnum, position)
+    JOIN pg_catalog.pg_attribute a ON a.attrelid = t.tgrelid AND a.attnum = k.attnum
+    ORDER BY k.position
+  ),
+  t.tgoldtable,
+  t.tgnewtable,
+  t.tgenabled,
+  t.tgconstraint <> 0,
+  t.tgdeferrable,
+  t.tginitdeferred,
+  t.tgfoid::regproc::text,
+  encode(t.tgargs, 'escape'),
+  pg_get_triggerdef(t.oid),
+  obj_description(t.oid, 'pg_trigger')
+FROM pg_catalog.pg_trigger t
+WHERE t.tgrelid = quote_ident($1)::regclass AND
+  NOT t.tgisinternal AND
+  -- Triggers cloned onto partitions follow the one of the parent, the
+  -- column was added in PostgreSQL 13
+  COALESCE((to_jsonb(t)->>'tgparentid')::oid, 0) = 0
+ORDER BY t.tgname
+`
+
+type TriggerState string
+
+const (
+	// Fires in origin and local mode, the default
+	TriggerEnabled  TriggerState = "O"
+	TriggerDisabled              = "D"
+	TriggerReplica               = "R"
+	TriggerAlways                = "A"
+)
+
+type Trigger struct {
+	name         string
+	timing       string
+	events       stringArray
+	forEachRow   bool
+	condition    sql.NullString
+	columns      stringArray
+	oldTable     sql.NullString
+	newTable     sql.NullString
+	state        TriggerState
+	isConstraint bool
+	deferrable   bool
+	deferred     bool
+	function     string
+	arguments    string
+	definition   string
+	table        *Table
+	comment      sql.NullString
+}
+
+func getTriggers(db *sql.DB, table *Table) ([]*Trigger, error) {
+	var rows *sql.Rows
+	var triggers []*Trigger
+	var err error
+	if rows, err = db.Query(GetTriggers, table.name); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var trigger Trigger
+		if err = rows.Scan(
+			&trigger.name,
+			&trigger.timing,
+			&trigger.events,
+			&trigger.forEachRow,
+			&trigger.condition,
+			&trigger.columns,
+			&trigger.oldTable,
+			&trigger.newTable,
+			&trigger.state,
+			&trigger.isConstraint,
+			&trigger.deferrable,
+			&trigger.deferred,
+			&trigger.function,
+			&trigger.arguments,
+			&trigger.definition,
+			&trigger.comment,
+		); err != nil {
+			return nil, err
+		}
+		trigger.table = table
+		triggers = append(triggers, &trigger)
+	}
+	return triggers, nil
+}
+
+func (trigger *Trigger) stateStatement() string {
+	var action string
+	switch trigger.state {
+	case TriggerDisabled:
+		action = "DISABLE"
+	case TriggerReplica:
+		action = "ENABLE REPLICA"
+	case TriggerAlways:
+		action = "ENABLE ALWAYS"
+	default:
+		action = "ENABLE"
+	}
+	return fmt.Sprintf("ALTER TABLE \"%s\" %s TRIGGER \"%s\";\n", trigger.table.name, action, trigger.name)
+}
+
+func (trigger *Trigger) CreateStatement() string {
+	var builder strings.Builder
+	// pg_get_triggerdef() knows about every clause, including the
+	// transition tables and constraint trigger options
+	builder.WriteString(fmt.Sprintf("%s;\n", trigger.definition))
+	if trigger.state != TriggerEnabled {
+		builder.WriteString(trigger.stateStatement())
+	}
+	return builder.String()
+}
+
+func (trigger *Trigger) DropStatement() string {
+	return fmt.Sprintf("DROP TRIGGER IF EXISTS \"%s\" ON \"%s\";\n", trigger.name, trigger.table.name)
+}
+
+// equals compares what both triggers do, unlike the definition it does
+// not depend on the name of the schema of the table
+func (trigger *Trigger) equals(other *Trigger) bool {
+	return trigger.timing == other.timing &&
+		strings.Join(trigger.events, ",") == strings.Join(other.events, ",") &&
+		trigger.forEachRow == other.forEachRow &&
+		trigger.condition == other.condition &&
+		strings.Join(trigger.columns, ",") == strings.Join(other.columns, ",") &&
+		trigger.oldTable == other.oldTable &&
+		trigger.newTable == other.newTable &&
+		trigger.isConstraint == other.isConstraint &&
+		trigger.deferrable == other.deferrable &&
+		trigger.deferred == other.deferred &&
+		trigger.function == other.function &&
+		trigger.arguments == other.arguments
+}
+
+func (table *Table) FindTrigger(name string) *Trigger {
+	for _, trigger := range table.triggers {
+		if trigger.name == name {
+			return trigger
+		}
+	}
+	return nil
+}
+
+// triggerDiff generates the statements that turn the triggers of the
+// target table into the ones of this table
+func (table *Table) triggerDiff(target *Table) string {
+	var builder strings.Builder
+	for _, trigger := range table.triggers {
+		var found *Trigger
+		found = target.FindTrigger(trigger.name)
+		if found == nil {
+			builder.WriteString(target.annotate(trigger.CreateStatement(), ShareRowExclusive, MetadataOnly))
+		} else if !found.equals(trigger) {
+			builder.WriteString(target.annotate(found.DropStatement(), AccessExclusive, MetadataOnly))
+			builder.WriteString(target.annotate(trigger.CreateStatement(), ShareRowExclusive, MetadataOnly))
+		} else if found.state != trigger.state {
+			builder.WriteString(target.annotate(trigger.stateStatement(), ShareRowExclusive, MetadataOnly))
+		}
+	}
+	for _, trigger := range target.triggers {
+		if table.FindTrigger(trigger.name) == nil {
+			builder.WriteString(target.annotate(trigger.DropStatement(), AccessExclusive, MetadataOnly))
+		}
+	}
+	return builder.String()
+}
@@ -1,48 +1,604 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"strings"
 )
 
 const GetTypes = `
-SELECT t.typname               AS name,
-       ARRAY_AGG(e.enumlabel)  AS values,
-       e.enumtypid IS NOT NULL AS is_enum
+SELECT t.oid,
+       t.typname                                                            AS name,
+       ARRAY_AGG(e.enumlabel ORDER BY e.enumsortorder)
+         FILTER (WHERE e.enumlabel IS NOT NULL)                             AS values,
+       t.typtype                                                            AS kind,
+       CASE WHEN t.typtype = 'd' THEN format_type(t.typbasetype, t.typtypmod) END AS base_type,
+       t.typnotnull                                                         AS not_null,
+       t.typdefault                                                         AS default_value,
+       format_type(r.rngsubtype, NULL)                                      AS subtype,
+       CASE WHEN NOT opc.opcdefault THEN opc.opcname END                    AS subtype_opclass,
+       CASE WHEN r.rngcanonical <> 0 THEN r.rngcanonical::regproc::text END AS canonical,
+       CASE WHEN r.rngsubdiff <> 0 THEN r.rngsubdiff::regproc::text END     AS subtype_diff,
+       COALESCE(t.typacl, acldefault('T', t.typowner))                      AS acl,
+       pg_get_userbyid(t.typowner)                                          AS owner,
+       obj_description(t.oid, 'pg_type')                                    AS comment
 FROM pg_catalog.pg_type t
        LEFT JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
        LEFT JOIN pg_catalog.pg_enum e ON e.enumtypid = t.oid
+       LEFT JOIN pg_catalog.pg_range r ON r.rngtypid = t.oid
+       LEFT JOIN pg_catalog.pg_opclass opc ON opc.oid = r.rngsubopc
 WHERE (t.typrelid = 0 OR (SELECT c.relkind = 'c' FROM pg_catalog.pg_class c WHERE c.oid = t.typrelid))
   AND NOT EXISTS(SELECT 1 FROM pg_catalog.pg_type el WHERE el.oid = t.typelem AND el.typarray = t.oid)
+  AND t.typtype IN ('e', 'r', 'd', 'c')
   AND n.nspname <> 'pg_catalog'
   AND n.nspname <> 'information_schema'
   AND pg_catalog.pg_type_is_visible(t.oid)
   AND n.nspname = $1
-GROUP BY t.typname, e.enumtypid
+  AND NOT EXISTS(
+    SELECT 1
+    FROM pg_catalog.pg_depend d
+    WHERE d.classid = 'pg_catalog.pg_type'::regclass AND d.objid = t.oid AND d.deptype = 'e'
+  )
+GROUP BY t.oid, r.rngtypid, opc.oid
+ORDER BY array_position(ARRAY['e', 'r', 'd', 'c'], t.typtype::text), t.typname
 `
 
+const GetTypeAttributes = `
+SELECT a.attname,
+       format_type(a.atttypid, a.atttypmod)
+FROM pg_catalog.pg_type t
+       JOIN pg_catalog.pg_attribute a ON a.attrelid = t.typrelid
+WHERE t.oid = $1
+  AND a.attnum > 0
+  AND NOT a.attisdropped
+ORDER BY a.attnum
+`
+
+const GetDomainConstraints = `
+SELECT conname,
+       pg_get_constraintdef(oid)
+FROM pg_catalog.pg_constraint
+WHERE contypid = $1
+ORDER BY conname
+`
+
+// GetTypeColumns lists the table columns of the type or of its array
+// type, inherited columns follow the ones of their parent
+const GetTypeColumns = `
+SELECT c.relname,
+       a.attname
+FROM pg_catalog.pg_type t
+       JOIN pg_catalog.pg_depend d
+            ON d.refclassid = 'pg_catalog.pg_type'::regclass AND d.refobjid IN (t.oid, t.typarray)
+       JOIN pg_catalog.pg_class c ON c.oid = d.objid
+       JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = d.objsubid
+WHERE t.oid = $1
+  AND d.classid = 'pg_catalog.pg_class'::regclass
+  AND c.relkind IN ('r', 'p')
+  AND c.relnamespace = t.typnamespace
+  AND a.attinhcount = 0
+ORDER BY c.relname, a.attnum
+`
+
+// GetTypeViews lists the views using the type, or a column of it, directly
+// or through other views, ordered so that every view comes after the ones
+// it uses
+const GetTypeViews = `
+WITH RECURSIVE columns(oid, attnum) AS (
+  SELECT d.objid, d.objsubid
+  FROM pg_catalog.pg_type t
+  JOIN pg_catalog.pg_depend d
+    ON d.refclassid = 'pg_catalog.pg_type'::regclass AND d.refobjid IN (t.oid, t.typarray)
+  WHERE t.oid = $1 AND d.classid = 'pg_catalog.pg_class'::regclass
+), views(oid, depth) AS (
+  SELECT r.ev_class, 1
+  FROM pg_catalog.pg_type t
+  JOIN pg_catalog.pg_depend d ON d.classid = 'pg_catalog.pg_rewrite'::regclass
+  JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+  WHERE t.oid = $1 AND r.ev_class <> d.refobjid AND (
+    (d.refclassid = 'pg_catalog.pg_type'::regclass AND d.refobjid IN (t.oid, t.typarray)) OR
+    (d.refclassid = 'pg_catalog.pg_class'::regclass AND (d.refobjid, d.refobjsubid) IN (SELECT * FROM columns))
+  )
+  UNION
+  SELECT r.ev_class, views.depth + 1
+  FROM views
+  JOIN pg_catalog.pg_depend d
+    ON d.classid = 'pg_catalog.pg_rewrite'::regclass AND
+    d.refclassid = 'pg_catalog.pg_class'::regclass AND
+    d.refobjid = views.oid
+  JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+  WHERE r.ev_class <> views.oid
+)
+SELECT c.relname, c.relkind = 'm', pg_get_viewdef(c.oid), ARRAY(
+  SELECT pg_get_triggerdef(t.oid)
+  FROM pg_catalog.pg_trigger t
+  WHERE t.tgrelid = c.oid AND NOT t.tgisinternal
+  ORDER BY t.tgname
+)
+FROM views
+JOIN pg_catalog.pg_class c ON c.oid = views.oid
+GROUP BY c.oid
+ORDER BY MAX(views.depth), c.relname
+`
+
+// GetTypeFunctions lists the functions taking or returning the type or
+// an array of it
+const GetTypeFunctions = `
+SELECT DISTINCT n.nspname,
+       p.proname,
+       oidvectortypes(p.proargtypes)
+FROM pg_catalog.pg_type t
+       JOIN pg_catalog.pg_depend d
+            ON d.refclassid = 'pg_catalog.pg_type'::regclass AND d.refobjid IN (t.oid, t.typarray)
+       JOIN pg_catalog.pg_proc p ON p.oid = d.objid
+       JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+WHERE t.oid = $1
+  AND d.classid = 'pg_catalog.pg_proc'::regclass
+ORDER BY 1, 2, 3
+`
+
+type TypeKind string
+
+const (
+	Enum      TypeKind = "e"
+	Range              = "r"
+	Domain             = "d"
+	Composite          = "c"
+)
+
+// Attribute is either a field of a composite type or a named constraint of
+// a domain, in both cases it is just a name and a definition
+type Attribute struct {
+	name       string
+	definition string
+}
+
 type Type struct {
-	name   string
-	isEnum bool
-	values []string
-	oid    int
+	name           string
+	kind           TypeKind
+	values         []string
+	oid            int
+	attributes     []*Attribute
+	baseType       sql.NullString
+	notNull        bool
+	defaultValue   sql.NullString
+	constraints    []*Attribute
+	subtype        sql.NullString
+	subtypeOpClass sql.NullString
+	canonical      sql.NullString
+	subtypeDiff    sql.NullString
+	acl            aclArray
+	owner          string
+	comment        sql.NullString
+	// Set on enums of the target replaced by a new type, which comes with
+	// the privileges, owner and comment of a new type
+	recreated bool
+}
+
+// TypeDependents are the objects of the target using an enum, they have to
+// follow it when the enum is recreated
+type TypeDependents struct {
+	columns   []*Column
+	views     string
+	dropViews string
+	// Views of the target among them
+	relations []*Table
+	functions []*Function
+	// Functions that cannot be recreated, e.g. those of other schemas
+	foreign []string
+}
+
+func getAttributes(db *sql.DB, query string, oid int) ([]*Attribute, error) {
+	var rows *sql.Rows
+	var attributes []*Attribute
+	var err error
+	if rows, err = db.Query(query, oid); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var attribute Attribute
+		if err = rows.Scan(&attribute.name, &attribute.definition); err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, &attribute)
+	}
+	return attributes, nil
+}
+
+func (item *Type) collectDetails(db *sql.DB) error {
+	var err error
+	switch item.kind {
+	case Composite:
+		item.attributes, err = getAttributes(db, GetTypeAttributes, item.oid)
+	case Domain:
+		item.constraints, err = getAttributes(db, GetDomainConstraints, item.oid)
+	}
+	return err
+}
+
+func findAttribute(attributes []*Attribute, name string) *Attribute {
+	for _, attribute := range attributes {
+		if attribute.name == name {
+			return attribute
+		}
+	}
+	return nil
+}
+
+func (item *Type) keyword() string {
+	if item.kind == Domain {
+		return "DOMAIN"
+	}
+	return "TYPE"
 }
 
 func (item *Type) DropStatement() string {
+	if item.kind == Domain {
+		return fmt.Sprintf("DROP DOMAIN \"%s\" CASCADE;\n", item.name)
+	}
 	return fmt.Sprintf("DROP TYPE \"%s\" CASCADE;\n", item.name)
 }
 
 func (item *Type) CreateStatement() string {
 	var builder strings.Builder
-	builder.WriteString("CREATE TYPE \"")
-	builder.WriteString(item.name)
-	builder.WriteString("\"")
-	if item.isEnum {
-		builder.WriteString(" AS ENUM ('")
-		builder.WriteString(strings.Join(item.values, "', '"))
-	} else {
+	switch item.kind {
+	case Enum:
+		builder.WriteString(fmt.Sprintf("CREATE TYPE \"%s\" AS ENUM ('", item.name))
+		builder.WriteString(strings.Join(quoteLiterals(item.values), "', '"))
+		builder.WriteString("');\n")
+	case Composite:
+		var list []string
+		for _, attribute := range item.attributes {
+			list = append(list, fmt.Sprintf("\"%s\" %s", attribute.name, attribute.definition))
+		}
+		builder.WriteString(
+			fmt.Sprintf("CREATE TYPE \"%s\" AS (\n  %s\n);\n", item.name, strings.Join(list, ",\n  ")),
+		)
+	case Domain:
+		builder.WriteString(fmt.Sprintf("CREATE DOMAIN \"%s\" AS %s", item.name, item.baseType.String))
+		if item.defaultValue.Valid {
+			builder.WriteString(fmt.Sprintf(" DEFAULT %s", item.defaultValue.String))
+		}
+		if item.notNull {
+			builder.WriteString(" NOT NULL")
+		}
+		for _, constraint := range item.constraints {
+			builder.WriteString(fmt.Sprintf(" CONSTRAINT \"%s\" %s", constraint.name, constraint.definition))
+		}
+		builder.WriteString(";\n")
+	case Range:
+		var list []string
+		list = append(list, fmt.Sprintf("SUBTYPE = %s", item.subtype.String))
+		if item.subtypeOpClass.Valid {
+			list = append(list, fmt.Sprintf("SUBTYPE_OPCLASS = %s", item.subtypeOpClass.String))
+		}
+		if item.canonical.Valid {
+			list = append(list, fmt.Sprintf("CANONICAL = %s", item.canonical.String))
+		}
+		if item.subtypeDiff.Valid {
+			list = append(list, fmt.Sprintf("SUBTYPE_DIFF = %s", item.subtypeDiff.String))
+		}
+		builder.WriteString(fmt.Sprintf("CREATE TYPE \"%s\" AS RANGE (%s);\n", item.name, strings.Join(list, ", ")))
+	default:
 		return fmt.Sprintf("-- \033[31mWARNING\033[0m: no idea how to create this type -> %s\n", item.name)
 	}
-	builder.WriteString("');\n")
 	return builder.String()
 }
+
+func indexOf(values []string, value string) int {
+	for index, item := range values {
+		if item == value {
+			return index
+		}
+	}
+	return -1
+}
+
+func quoteLiterals(values []string) []string {
+	var list []string
+	list = make([]string, len(values))
+	for index, value := range values {
+		list[index] = strings.ReplaceAll(value, "'", "''")
+	}
+	return list
+}
+
+// renamedValue returns the label in current that occupies the same place
+// as the new label, i.e. it follows the same label in both lists and is
+// no longer part of the wanted values, which means it was renamed
+func renamedValue(wanted []string, current []string, index int) string {
+	var position int
+	var candidate string
+	if index == 0 {
+		position = 0
+	} else if position = indexOf(current, wanted[index-1]); position == -1 {
+		return ""
+	} else {
+		position++
+	}
+	if position >= len(current) {
+		return ""
+	}
+	candidate = current[position]
+	if indexOf(wanted, candidate) != -1 {
+		return ""
+	}
+	return candidate
+}
+
+// recreateStatements replaces the enum by a new type with the wanted
+// labels, this is the only way to remove or reorder labels, rows still
+// holding a removed label will make the conversion fail
+func (item *Type) recreateStatements(renames string, dependents *TypeDependents) string {
+	var builder strings.Builder
+	var old string
+	old = fmt.Sprintf("%s__old", item.name)
+	builder.WriteString(
+		fmt.Sprintf("-- enum \"%s\" lost labels or changed order, it has to be recreated\n", item.name),
+	)
+	for _, name := range dependents.foreign {
+		builder.WriteString(
+			fmt.Sprintf(
+				"-- \033[31mWARNING\033[0m: function %s uses \"%s\", it has to be recreated manually\n",
+				name,
+				item.name,
+			),
+		)
+	}
+	// Views and functions keep using the old type, they go away first
+	builder.WriteString(dependents.dropViews)
+	for _, view := range dependents.relations {
+		view.recreated = true
+	}
+	for _, function := range dependents.functions {
+		builder.WriteString(function.DropStatement())
+		function.recreated = true
+	}
+	builder.WriteString(renames)
+	builder.WriteString(fmt.Sprintf("ALTER TYPE \"%s\" RENAME TO \"%s\";\n", item.name, old))
+	builder.WriteString(item.CreateStatement())
+	for _, column := range dependents.columns {
+		var table *Table = column.table
+		var defaultValue string
+		var suffix string
+		var err error
+		if strings.HasSuffix(column.dataType, "[]") {
+			suffix = "[]"
+		}
+		defaultValue, err = column.GetDefaultValue()
+		if err == nil {
+			builder.WriteString(
+				fmt.Sprintf("ALTER TABLE \"%s\" ALTER COLUMN \"%s\" DROP DEFAULT;\n", table.name, column.name),
+			)
+		}
+		builder.WriteString(table.annotate(
+			fmt.Sprintf(
+				"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" TYPE \"%s\"%s USING \"%s\"::text%s::\"%s\"%s;\n",
+				table.name,
+				column.name,
+				item.name,
+				suffix,
+				column.name,
+				suffix,
+				item.name,
+				suffix,
+			),
+			AccessExclusive,
+			FullRewrite,
+		))
+		if err == nil {
+			builder.WriteString(
+				fmt.Sprintf(
+					"ALTER TABLE \"%s\" ALTER COLUMN \"%s\" SET DEFAULT %s;\n",
+					table.name,
+					column.name,
+					defaultValue,
+				),
+			)
+		}
+	}
+	builder.WriteString(fmt.Sprintf("DROP TYPE \"%s\";\n", old))
+	// The definitions were read before the rename, they use the new type
+	for _, function := range dependents.functions {
+		builder.WriteString(function.CreateStatement())
+	}
+	builder.WriteString(dependents.views)
+	return builder.String()
+}
+
+func (item *Type) compositeDiff(target *Type) string {
+	var builder strings.Builder
+	for _, attribute := range item.attributes {
+		var found *Attribute
+		found = findAttribute(target.attributes, attribute.name)
+		if found == nil {
+			builder.WriteString(
+				fmt.Sprintf(
+					"ALTER TYPE \"%s\" ADD ATTRIBUTE \"%s\" %s;\n",
+					item.name,
+					attribute.name,
+					attribute.definition,
+				),
+			)
+		} else if found.definition != attribute.definition {
+			builder.WriteString(
+				fmt.Sprintf(
+					"ALTER TYPE \"%s\" ALTER ATTRIBUTE \"%s\" TYPE %s;\n",
+					item.name,
+					attribute.name,
+					attribute.definition,
+				),
+			)
+		}
+	}
+	for _, attribute := range target.attributes {
+		if findAttribute(item.attributes, attribute.name) == nil {
+			builder.WriteString(
+				fmt.Sprintf("ALTER TYPE \"%s\" DROP ATTRIBUTE IF EXISTS \"%s\";\n", item.name, attribute.name),
+			)
+		}
+	}
+	return builder.String()
+}
+
+func (item *Type) domainDiff(target *Type) string {
+	var builder strings.Builder
+	if item.baseType != target.baseType {
+		// There is no ALTER DOMAIN for this, every column using it
+		// would be dropped by DROP DOMAIN ... CASCADE
+		return fmt.Sprintf(
+			"-- \033[31mWARNING\033[0m: base type of domain \"%s\" changed from %s to %s, it has to be recreated manually\n",
+			item.name,
+			target.baseType.String,
+			item.baseType.String,
+		)
+	}
+	if item.defaultValue.Valid && item.defaultValue != target.defaultValue {
+		builder.WriteString(
+			fmt.Sprintf("ALTER DOMAIN \"%s\" SET DEFAULT %s;\n", item.name, item.defaultValue.String),
+		)
+	} else if !item.defaultValue.Valid && target.defaultValue.Valid {
+		builder.WriteString(fmt.Sprintf("ALTER DOMAIN \"%s\" DROP DEFAULT;\n", item.name))
+	}
+	if item.notNull && !target.notNull {
+		builder.WriteString(fmt.Sprintf("ALTER DOMAIN \"%s\" SET NOT NULL;\n", item.name))
+	} else if !item.notNull && target.notNull {
+		builder.WriteString(fmt.Sprintf("ALTER DOMAIN \"%s\" DROP NOT NULL;\n", item.name))
+	}
+	for _, constraint := range target.constraints {
+		var found *Attribute
+		found = findAttribute(item.constraints, constraint.name)
+		if found == nil || found.definition != constraint.definition {
+			builder.WriteString(
+				fmt.Sprintf("ALTER DOMAIN \"%s\" DROP CONSTRAINT IF EXISTS \"%s\";\n", item.name, constraint.name),
+			)
+		}
+	}
+	for _, constraint := range item.constraints {
+		var found *Attribute
+		found = findAttribute(target.constraints, constraint.name)
+		if found == nil || found.definition != constraint.definition {
+			builder.WriteString(
+				fmt.Sprintf(
+					"ALTER DOMAIN \"%s\" ADD CONSTRAINT \"%s\" %s;\n",
+					item.name,
+					constraint.name,
+					constraint.definition,
+				),
+			)
+		}
+	}
+	return builder.String()
+}
+
+func (item *Type) rangeDiff(target *Type) string {
+	if item.subtype != target.subtype ||
+		item.subtypeOpClass != target.subtypeOpClass ||
+		item.canonical != target.canonical ||
+		item.subtypeDiff != target.subtypeDiff {
+		// Range types cannot be altered, and dropping them drops every
+		// column using them
+		return fmt.Sprintf(
+			"-- \033[31mWARNING\033[0m: definition of range \"%s\" changed, it has to be recreated manually\n",
+			item.name,
+		)
+	}
+	return ""
+}
+
+// Diff generates the statements that turn the target type into this one,
+// the new labels of an enum are written to the prepare phase; dependents
+// are the objects of the target using the type, they are only needed if an
+// enum has to be recreated
+func (item *Type) Diff(target *Type, dependents *TypeDependents, migration *Migration) string {
+	var builder strings.Builder
+	var renames strings.Builder
+	var current []string
+	if item.kind != target.kind {
+		// Dropping the type would cascade to every column using it
+		return fmt.Sprintf(
+			"-- \033[31mWARNING\033[0m: type \"%s\" changed its kind, it has to be recreated manually\n",
+			item.name,
+		)
+	}
+	switch item.kind {
+	case Composite:
+		return item.compositeDiff(target)
+	case Domain:
+		return item.domainDiff(target)
+	case Range:
+		return item.rangeDiff(target)
+	}
+	current = make([]string, len(target.values))
+	copy(current, target.values)
+	// Renames first, so that they are not seen as additions
+	for index, value := range item.values {
+		var renamed string
+		if indexOf(current, value) != -1 {
+			continue
+		}
+		renamed = renamedValue(item.values, current, index)
+		if renamed == "" {
+			continue
+		}
+		renames.WriteString(
+			fmt.Sprintf(
+				"ALTER TYPE \"%s\" RENAME VALUE '%s' TO '%s';\n",
+				item.name,
+				strings.ReplaceAll(renamed, "'", "''"),
+				strings.ReplaceAll(value, "'", "''"),
+			),
+		)
+		current[indexOf(current, renamed)] = value
+	}
+	// Then the new labels, each one placed relative to its predecessor
+	for index, value := range item.values {
+		var position int
+		if indexOf(current, value) != -1 {
+			continue
+		}
+		if index > 0 {
+			builder.WriteString(
+				fmt.Sprintf(
+					"ALTER TYPE \"%s\" ADD VALUE IF NOT EXISTS '%s' AFTER '%s';\n",
+					item.name,
+					strings.ReplaceAll(value, "'", "''"),
+					strings.ReplaceAll(item.values[index-1], "'", "''"),
+				),
+			)
+			position = indexOf(current, item.values[index-1]) + 1
+		} else if len(current) > 0 {
+			builder.WriteString(
+				fmt.Sprintf(
+					"ALTER TYPE \"%s\" ADD VALUE IF NOT EXISTS '%s' BEFORE '%s';\n",
+					item.name,
+					strings.ReplaceAll(value, "'", "''"),
+					strings.ReplaceAll(current[0], "'", "''"),
+				),
+			)
+			position = 0
+		} else {
+			builder.WriteString(
+				fmt.Sprintf(
+					"ALTER TYPE \"%s\" ADD VALUE IF NOT EXISTS '%s';\n",
+					item.name,
+					strings.ReplaceAll(value, "'", "''"),
+				),
+			)
+			position = 0
+		}
+		current = append(current[:position], append([]string{value}, current[position:]...)...)
+	}
+	// Whatever is left over was either removed or moved around
+	if strings.Join(current, "\x00") != strings.Join(item.values, "\x00") {
+		target.recreated = true
+		return item.recreateStatements(renames.String(), dependents)
+	}
+	if builder.Len() == 0 {
+		return renames.String()
+	}
+	// New labels cannot be used in the transaction adding them, the renames
+	// go along since new labels may be placed next to them
+	migration.Write(Prepare, renames.String()+builder.String())
+	return ""
+}
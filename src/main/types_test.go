@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenamedValue(t *testing.T) {
+	var tests = []struct {
+		wanted  []string
+		current []string
+		index   int
+		renamed string
+	}{
+		{[]string{"a", "c"}, []string{"a", "b"}, 1, "b"},
+		{[]string{"c", "b"}, []string{"a", "b"}, 0, "a"},
+		{[]string{"a", "x", "b"}, []string{"a", "b"}, 1, ""},
+		{[]string{"a", "b", "c"}, []string{"a", "b"}, 2, ""},
+		{[]string{"x", "c"}, []string{"a", "b"}, 1, ""},
+	}
+	for _, test := range tests {
+		var renamed string = renamedValue(test.wanted, test.current, test.index)
+		if renamed != test.renamed {
+			t.Logf("%q from %q at %d: expected %q, got %q", test.wanted, test.current, test.index, test.renamed, renamed)
+			t.Fail()
+		}
+	}
+}
+
+func TestEnumDiff(t *testing.T) {
+	var tests = []struct {
+		name       string
+		wanted     []string
+		current    []string
+		statements []string
+		prepare    string
+		recreated  bool
+	}{
+		{
+			name:    "add after",
+			wanted:  []string{"a", "x", "b"},
+			current: []string{"a", "b"},
+			prepare: "ALTER TYPE \"e\" ADD VALUE IF NOT EXISTS 'x' AFTER 'a';\n",
+		},
+		{
+			name:    "add before",
+			wanted:  []string{"x", "a", "b"},
+			current: []string{"a", "b"},
+			prepare: "ALTER TYPE \"e\" ADD VALUE IF NOT EXISTS 'x' BEFORE 'a';\n",
+		},
+		{
+			name:    "add and quote",
+			wanted:  []string{"a", "it's"},
+			current: []string{"a"},
+			prepare: "ALTER TYPE \"e\" ADD VALUE IF NOT EXISTS 'it''s' AFTER 'a';\n",
+		},
+		{
+			name:       "rename",
+			wanted:     []string{"a", "c"},
+			current:    []string{"a", "b"},
+			statements: []string{"ALTER TYPE \"e\" RENAME VALUE 'b' TO 'c';\n"},
+		},
+		{
+			name:    "rename next to a new label",
+			wanted:  []string{"a", "c", "x"},
+			current: []string{"a", "b"},
+			prepare: "ALTER TYPE \"e\" RENAME VALUE 'b' TO 'c';\n" +
+				"ALTER TYPE \"e\" ADD VALUE IF NOT EXISTS 'x' AFTER 'c';\n",
+		},
+		{
+			name:    "reorder",
+			wanted:  []string{"b", "a"},
+			current: []string{"a", "b"},
+			statements: []string{
+				"ALTER TYPE \"e\" RENAME TO \"e__old\";\n",
+				"CREATE TYPE \"e\" AS ENUM ('b', 'a');\n",
+				"DROP TYPE \"e__old\";\n",
+			},
+			recreated: true,
+		},
+		{
+			name:    "remove",
+			wanted:  []string{"a"},
+			current: []string{"a", "b"},
+			statements: []string{
+				"CREATE TYPE \"e\" AS ENUM ('a');\n",
+			},
+			recreated: true,
+		},
+		{
+			name:    "unchanged",
+			wanted:  []string{"a", "b"},
+			current: []string{"a", "b"},
+		},
+	}
+	for _, test := range tests {
+		var migration *Migration = NewMigration(Options{})
+		var wanted *Type = &Type{name: "e", kind: Enum, values: test.wanted}
+		var current *Type = &Type{name: "e", kind: Enum, values: test.current}
+		var statements string = wanted.Diff(current, &TypeDependents{}, migration)
+		for _, statement := range test.statements {
+			if !strings.Contains(statements, statement) {
+				t.Logf("%s: expected %q in %q", test.name, statement, statements)
+				t.Fail()
+			}
+		}
+		if len(test.statements) == 0 && statements != "" {
+			t.Logf("%s: expected nothing, got %q", test.name, statements)
+			t.Fail()
+		}
+		if prepare := migration.Script(Prepare); prepare != test.prepare {
+			t.Logf("%s: expected %q to be prepared, got %q", test.name, test.prepare, prepare)
+			t.Fail()
+		}
+		if current.recreated != test.recreated {
+			t.Logf("%s: expected recreated to be %t", test.name, test.recreated)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// AclItem is a single entry of an aclitem[] value, e.g. `app=arwd*/owner`
+type AclItem struct {
+	// Empty when the privileges are granted to PUBLIC
+	Grantee string
+	// One letter per privilege, as printed by the server
+	Privileges string
+	// The subset of Privileges that can be granted to others
+	Grantable string
+	Grantor   string
+}
+
+// parseRoleName reads a role name, possibly double quoted, and returns it
+// along with what is left of the input
+func parseRoleName(text string) (string, string, error) {
+	var builder strings.Builder
+	var index int
+	if !strings.HasPrefix(text, "\"") {
+		index = strings.IndexAny(text, "=/")
+		if index == -1 {
+			return text, "", nil
+		}
+		return text[:index], text[index:], nil
+	}
+	for index = 1; index < len(text); index++ {
+		if text[index] != '"' {
+			builder.WriteByte(text[index])
+		} else if index+1 < len(text) && text[index+1] == '"' {
+			// Doubled quote inside the quoted name
+			builder.WriteByte('"')
+			index++
+		} else {
+			return builder.String(), text[index+1:], nil
+		}
+	}
+	return "", "", errors.New("unterminated quoted role name")
+}
+
+func ParseAclItem(text string) (AclItem, error) {
+	var item AclItem
+	var rest string
+	var err error
+	var index int
+	if item.Grantee, rest, err = parseRoleName(text); err != nil {
+		return item, err
+	}
+	if !strings.HasPrefix(rest, "=") {
+		return item, errors.New("expected `=' after the grantee")
+	}
+	rest = rest[1:]
+	index = strings.IndexByte(rest, '/')
+	if index == -1 {
+		return item, errors.New("expected `/' before the grantor")
+	}
+	for position, chr := range rest[:index] {
+		if chr == '*' {
+			if position == 0 {
+				return item, errors.New("unexpected `*' before any privilege")
+			}
+			item.Grantable += string(rest[position-1])
+		} else {
+			item.Privileges += string(chr)
+		}
+	}
+	if item.Grantor, _, err = parseRoleName(rest[index+1:]); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// ParseAcl parses the text representation of an aclitem[] value
+func ParseAcl(data []byte, acl *[]AclItem) error {
+	var items []string
+	var err error
+	if err = ParseArray(data, &items); err != nil {
+		return err
+	}
+	for _, text := range items {
+		var item AclItem
+		if item, err = ParseAclItem(text); err != nil {
+			return err
+		}
+		*acl = append(*acl, item)
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ const (
 	ScanningItems     = 0x0001
 	QuotedString      = 0x0002
 	Escaping          = 0x0004
+	QuotedItem        = 0x0008
 )
 
 func parserParseSingleCharacter(chr byte, builder *strings.Builder, state int, array *[]string) (int, error) {
@@ -21,6 +22,10 @@ func parserParseSingleCharacter(chr byte, builder *strings.Builder, state int, a
 		state &= ^Escaping
 		// Just go to the next character now
 		return state, nil
+	} else if state&QuotedString == QuotedString && chr == '\\' {
+		// The next character is taken as is
+		state |= Escaping
+		return state, nil
 	} else if state&QuotedString == QuotedString && chr != '"' {
 		// If inside a quoted string, just read the value
 		builder.WriteByte(chr)
@@ -36,8 +41,11 @@ func parserParseSingleCharacter(chr byte, builder *strings.Builder, state int, a
 		state = ScanningItems
 		break
 	case '}':
-		if state == ScanningItems {
-			*array = append(*array, builder.String())
+		if state&ScanningItems == ScanningItems {
+			// An empty array has no items at all, but {""} has one
+			if builder.Len() > 0 || len(*array) > 0 || state&QuotedItem == QuotedItem {
+				*array = append(*array, builder.String())
+			}
 			// We're done here
 			return state, nil
 		} else {
@@ -53,7 +61,7 @@ func parserParseSingleCharacter(chr byte, builder *strings.Builder, state int, a
 		if state&QuotedString == QuotedString {
 			state &= ^QuotedString
 		} else {
-			state |= QuotedString
+			state |= QuotedString | QuotedItem
 		}
 		break
 	case ' ':
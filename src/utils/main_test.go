@@ -21,3 +21,71 @@ func TestArrayParser(t *testing.T) {
 		t.Logf("%s", content)
 	}
 }
+
+func TestEmptyArray(t *testing.T) {
+	var content []string
+	var err error
+	err = utils.ParseArray([]byte("{}"), &content)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if len(content) != 0 {
+		t.Logf("expected no items, got %q", content)
+		t.Fail()
+	}
+}
+
+func TestEmptyStringArray(t *testing.T) {
+	var content []string
+	var err error
+	err = utils.ParseArray([]byte(`{""}`), &content)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if len(content) != 1 || content[0] != "" {
+		t.Logf("expected one empty item, got %q", content)
+		t.Fail()
+	}
+}
+
+func TestQuotedArray(t *testing.T) {
+	var content []string
+	var err error
+	err = utils.ParseArray([]byte(`{"a b","c\"d",e}`), &content)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if len(content) != 3 || content[0] != "a b" || content[1] != `c"d` || content[2] != "e" {
+		t.Logf("unexpected items %q", content)
+		t.Fail()
+	}
+}
+
+func TestAclParser(t *testing.T) {
+	var acl []utils.AclItem
+	var err error
+	err = utils.ParseAcl([]byte(`{postgres=arwdDxt/postgres,=r/postgres,"\"app user\"=r*w/postgres"}`), &acl)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if len(acl) != 3 {
+		t.Logf("expected 3 items, got %d", len(acl))
+		t.FailNow()
+	}
+	if acl[0].Grantee != "postgres" || acl[0].Privileges != "arwdDxt" || acl[0].Grantor != "postgres" {
+		t.Logf("unexpected item %+v", acl[0])
+		t.Fail()
+	}
+	if acl[1].Grantee != "" || acl[1].Privileges != "r" {
+		t.Logf("unexpected item %+v", acl[1])
+		t.Fail()
+	}
+	if acl[2].Grantee != "app user" || acl[2].Privileges != "rw" || acl[2].Grantable != "r" {
+		t.Logf("unexpected item %+v", acl[2])
+		t.Fail()
+	}
+}